@@ -37,6 +37,17 @@ func nonempty(s ...string) string {
 	return ""
 }
 
+// nonemptyData is like nonempty, but for message data; nil and empty string
+// are considered empty.
+func nonemptyData(data ...interface{}) interface{} {
+	for _, data := range data {
+		if data != nil && data != "" {
+			return data
+		}
+	}
+	return nil
+}
+
 func randomString(n int) string {
 	p := make([]byte, n/2+1)
 	rand.Read(p)
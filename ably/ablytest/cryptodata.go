@@ -23,10 +23,20 @@ type CryptoData struct {
 }
 
 func LoadCryptoData(rel string) (*CryptoData, []byte, []byte, error) {
+	path := filepath.Join("..", "..", "common", filepath.FromSlash(rel))
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, nil, errors.New("missing common subrepo - ensure git submodules are initialized")
+	}
+	return LoadCryptoDataFile(path)
+}
+
+// LoadCryptoDataFile is like LoadCryptoData, but reads the test data from
+// the given file, e.g. one in the testdata directory of a package.
+func LoadCryptoDataFile(path string) (*CryptoData, []byte, []byte, error) {
 	data := &CryptoData{}
-	f, err := os.Open(filepath.Join("..", "..", "common", filepath.FromSlash(rel)))
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, nil, nil, errors.New("missing common subrepo - ensure git submodules are initialized")
+		return nil, nil, nil, err
 	}
 	err = json.NewDecoder(f).Decode(data)
 	f.Close()
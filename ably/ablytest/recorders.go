@@ -335,7 +335,7 @@ func (rec *MessageRecorder) Dial(proto string, u *url.URL) (proto.Conn, error) {
 	rec.mu.Lock()
 	rec.url = append(rec.url, u)
 	rec.mu.Unlock()
-	conn, err := ablyutil.DialWebsocket(proto, u)
+	conn, err := ablyutil.DialWebsocket(proto, u, Timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -423,7 +423,7 @@ func NewRecorder(httpClient *http.Client) *HostRecorder {
 	}
 	hr.dialWS = func(proto string, u *url.URL) (proto.Conn, error) {
 		hr.addHost(u.Host)
-		return ablyutil.DialWebsocket(proto, u)
+		return ablyutil.DialWebsocket(proto, u, Timeout)
 	}
 	return hr
 }
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"encoding/base64"
 )
@@ -62,6 +63,41 @@ type Auth struct {
 	params   *TokenParams // save params to use with token renewal
 	host     string       // a host part of AuthURL
 	clientID string       // clientID of the authenticated user or wildcard "*"
+
+	// sendAuth passes a token obtained with Authorise to the realtime
+	// connection, if the client has one; it is nil for RestClient.
+	sendAuth func(*TokenDetails) error
+
+	flightMtx sync.Mutex
+	flight    *tokenFlight // token acquisition shared by concurrent requests
+
+	offset     time.Duration // difference between Ably server time and local time
+	timeSynced time.Time     // when offset was measured, with RestClient.Time or from a token; zero if never
+	renewals   map[chan<- TokenRenewal]struct{}
+	events     map[chan<- AuthEvent]struct{}
+	renewal    *time.Timer // fires when the token is due to be renewed in background
+	retries    int         // number of consecutive failed background renewals
+	stopped    bool        // whether background renewal was stopped for good by closing the client
+}
+
+// timeSyncInterval is how long the measured offset between local and server
+// clocks is used for, before it's measured again.
+const timeSyncInterval = 30 * time.Minute
+
+// tokenFlight is a single token acquisition, which result is shared by all
+// requests that needed the token while it was in progress.
+type tokenFlight struct {
+	done chan struct{} // closed once tok and err are set
+	tok  *TokenDetails
+	err  error
+}
+
+// TokenRenewal describes an outcome of a single background token renewal,
+// which is enabled with ClientOptions.TokenRenewalMargin.
+type TokenRenewal struct {
+	Token   *TokenDetails // renewed token; nil if the renewal failed
+	Err     error         // reason of the failure; nil if the renewal succeeded
+	RetryIn time.Duration // for a failed renewal, time left till next attempt; zero if renewal gave up
 }
 
 func newAuth(client *RestClient) (*Auth, error) {
@@ -87,9 +123,33 @@ func newAuth(client *RestClient) (*Auth, error) {
 	if a.clientID == "*" {
 		return nil, newError(40102, errWildcardClientID)
 	}
+	if a.method == authToken && a.opts().TokenDetails == nil && a.opts().TokenStore != nil {
+		a.loadToken()
+	}
 	return a, nil
 }
 
+// loadToken makes the client use the token from TokenStore, unless it has
+// expired or was issued for other ClientID.
+func (a *Auth) loadToken() {
+	tok, err := a.opts().TokenStore.Load()
+	switch {
+	case err != nil:
+		a.logger().Printf(LogWarning, "Auth: unable to load token from TokenStore: %v", err)
+	case tok == nil:
+	case a.expired(tok):
+		a.logger().Printf(LogInfo, "Auth: discarding expired token from TokenStore")
+	case areClientIDsSet(a.clientID, tok.ClientID) && a.clientID != tok.ClientID:
+		a.logger().Printf(LogInfo, "Auth: discarding token from TokenStore issued for ClientID %q", tok.ClientID)
+	default:
+		a.opts().TokenDetails = tok
+		if tok.ClientID != "" {
+			a.clientID = tok.ClientID
+		}
+		a.scheduleRenewal(tok)
+	}
+}
+
 // ClientID
 func (a *Auth) ClientID() string {
 	a.mtx.Lock()
@@ -113,7 +173,10 @@ func (a *Auth) updateClientID(clientID string) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 	if clientID != "*" && clientID != "" {
-		a.clientID = clientID
+		if a.clientID != clientID {
+			a.clientID = clientID
+			a.emit(AuthEvent{Type: AuthClientIDChanged})
+		}
 	}
 }
 
@@ -198,7 +261,30 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		}
 		tokReq = req
 	}
-	tok = &TokenDetails{}
+	tok, err = a.exchangeTokenRequest(tokReq)
+	if code(err) == ErrCodeTimestampNotCurrent {
+		// Local clock is off, measure the offset again.
+		if e := a.syncTime(true); e != nil {
+			return nil, "", err
+		}
+		if opts.AuthCallback == nil && opts.AuthURL == "" && (params == nil || params.Timestamp == 0) {
+			// The request was signed here, so it's signed again with
+			// the server time.
+			if tokReq, err = a.createTokenRequest(params, opts); err != nil {
+				return nil, "", err
+			}
+			tok, err = a.exchangeTokenRequest(tokReq)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return tok, tokReqClientID, nil
+}
+
+// exchangeTokenRequest requests a token from Ably for the signed tokReq.
+func (a *Auth) exchangeTokenRequest(tokReq *TokenRequest) (*TokenDetails, error) {
+	tok := &TokenDetails{}
 	r := &request{
 		Method: "POST",
 		Path:   "/keys/" + tokReq.KeyName + "/requestToken",
@@ -207,56 +293,111 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		NoAuth: true,
 	}
 	if _, err := a.client.do(r); err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	return tok, tokReqClientID, nil
+	if tok.Issued != 0 && a.timeSynced.IsZero() {
+		// The token was issued by Ably just now, so its issue time tells
+		// how much local clock differs from the server one. It's used like
+		// the offset measured with syncTime, so token requests and expiry
+		// checks agree on the server time.
+		now := time.Now()
+		a.offset = tok.IssueTime().Sub(now)
+		a.timeSynced = now
+	}
+	return tok, nil
 }
 
 // Authorise
+//
+// If the client owns a live realtime connection, the obtained token is also
+// sent over it and Authorise waits until Ably confirms the connection was
+// reauthorised with the new capabilities and ClientID.
 func (a *Auth) Authorise(params *TokenParams, opts *AuthOptions) (*TokenDetails, error) {
 	a.mtx.Lock()
-	defer a.mtx.Unlock()
 	force := a.opts().Force
 	if opts != nil && opts.Force {
 		force = true
 	}
-	return a.authorise(params, opts, force)
+	tok, err := a.authorise(params, opts, force)
+	sendAuth := a.sendAuth
+	a.mtx.Unlock()
+	if err != nil || sendAuth == nil {
+		return tok, err
+	}
+	if err := sendAuth(tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
 }
 
 func (a *Auth) authorise(params *TokenParams, opts *AuthOptions, force bool) (*TokenDetails, error) {
 	switch tok := a.token(); {
-	case tok != nil && !force && (tok.Expires == 0 || !tok.Expired()):
+	case tok != nil && !force && !a.expired(tok):
 		return tok, nil
 	case params != nil && params.ClientID == "":
 		params.ClientID = a.clientID
 	case params == nil && a.clientID != "":
 		params = &TokenParams{ClientID: a.clientID}
 	}
+	source := a.tokenSource(opts)
 	tok, tokReqClientID, err := a.requestToken(params, opts)
-	if err != nil {
-		return nil, err
-	}
+	switch {
+	case err != nil:
 	// Fail if the non-empty ClientID, that was set explicitely via ClientOptions, does
 	// not match the non-wildcard ClientID returned with the token.
-	if areClientIDsSet(a.clientID, tok.ClientID) && a.clientID != tok.ClientID {
-		return nil, newError(40012, errClientIDMismatch)
-	}
+	case areClientIDsSet(a.clientID, tok.ClientID) && a.clientID != tok.ClientID:
+		err = newError(40012, errClientIDMismatch)
 	// Fail if non-empty ClientID requested by a TokenRequest
 	// does not match the non-wildcard ClientID that arrived with the token.
-	if areClientIDsSet(tokReqClientID, tok.ClientID) && tokReqClientID != tok.ClientID {
-		return nil, newError(40012, errClientIDMismatch)
+	case areClientIDsSet(tokReqClientID, tok.ClientID) && tokReqClientID != tok.ClientID:
+		err = newError(40012, errClientIDMismatch)
+	}
+	if err != nil {
+		a.emit(AuthEvent{Type: AuthTokenRejected, Source: source, Token: tok, Err: err})
+		return nil, err
+	}
+	event := AuthTokenRequested
+	if a.token() != nil {
+		event = AuthTokenRenewed
 	}
 	a.method = authToken
 	a.opts().TokenDetails = tok
 	a.params = params
+	prevClientID := a.clientID
 	a.clientID = tok.ClientID
+	a.emit(AuthEvent{Type: event, Source: source, Token: tok})
+	if a.clientID != prevClientID {
+		a.emit(AuthEvent{Type: AuthClientIDChanged})
+	}
+	a.scheduleRenewal(tok)
+	if store := a.opts().TokenStore; store != nil {
+		if err := store.Save(tok); err != nil {
+			a.logger().Printf(LogWarning, "Auth: unable to save token in TokenStore: %v", err)
+		}
+	}
 	return tok, nil
 }
 
-func (a *Auth) reauthorise() (*TokenDetails, error) {
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
-	return a.authorise(a.params, nil, true)
+// reauthorise requests a new token after Ably rejected the stale one.
+//
+// Concurrent callers share a single request, like with acquireToken. If
+// the current token is not the stale one anymore, because it was already
+// renewed in the meantime, it's given instead of requesting a new one.
+func (a *Auth) reauthorise(stale *TokenDetails) (*TokenDetails, error) {
+	renew := func() (*TokenDetails, error) {
+		a.mtx.Lock()
+		defer a.mtx.Unlock()
+		if tok := a.token(); tok != nil && tok != stale && !a.expired(tok) {
+			return tok, nil
+		}
+		return a.authorise(a.params, nil, true)
+	}
+	tok, err := a.fly(renew)
+	if err == nil && tok == stale {
+		// Joined an acquisition, which kept the stale token.
+		tok, err = a.fly(renew)
+	}
+	return tok, err
 }
 
 func (a *Auth) mergeOpts(opts *AuthOptions) *AuthOptions {
@@ -283,18 +424,40 @@ func (a *Auth) setDefaults(opts *AuthOptions, req *TokenRequest) error {
 	}
 	if req.Timestamp == 0 {
 		if opts.UseQueryTime {
-			t, err := a.client.Time()
-			if err != nil {
-				return newError(40100, err)
+			if err := a.syncTime(false); err != nil {
+				return err
 			}
-			req.Timestamp = Time(t)
-		} else {
+		}
+		if a.timeSynced.IsZero() {
 			req.Timestamp = TimeNow()
+		} else {
+			req.Timestamp = Time(a.serverTime())
 		}
 	}
 	return nil
 }
 
+// syncTime measures the offset between local and Ably server clocks with
+// RestClient.Time, unless force is false and the offset was measured within
+// the last timeSyncInterval.
+//
+// It must be called with a.mtx held.
+func (a *Auth) syncTime(force bool) error {
+	if !force && !a.timeSynced.IsZero() && time.Since(a.timeSynced) < timeSyncInterval {
+		return nil
+	}
+	start := time.Now()
+	t, err := a.client.Time()
+	if err != nil {
+		return newError(40100, err)
+	}
+	// Assume the server time was read in the middle of the round trip.
+	now := time.Now()
+	a.offset = t.Sub(start.Add(now.Sub(start) / 2))
+	a.timeSynced = now
+	return nil
+}
+
 func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface{}, error) {
 	req, err := http.NewRequest(opts.authMethod(), opts.AuthURL, nil)
 	if err != nil {
@@ -325,12 +488,12 @@ func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface
 		return nil, a.newError(40004, err)
 	}
 	switch typ {
-	case "text/plain":
+	case "text/plain", protocolJWT:
 		token, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, a.newError(40000, err)
 		}
-		return newTokenDetails(string(token)), nil
+		return newTokenDetails(strings.TrimSpace(string(token))), nil
 	case protocolJSON, protocolMsgPack:
 		var req TokenRequest
 		var buf bytes.Buffer
@@ -350,6 +513,162 @@ func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface
 	}
 }
 
+// serverTime gives current time of Ably servers, estimated with the last known
+// offset between local and server clocks.
+func (a *Auth) serverTime() time.Time {
+	return time.Now().Add(a.offset)
+}
+
+// expired is like tok.Expired, but accounts for local clock skew.
+func (a *Auth) expired(tok *TokenDetails) bool {
+	return tok.Expires != 0 && tok.Expires <= Time(a.serverTime())
+}
+
+// OnRenew relays outcomes of background token renewals to the given channel;
+// the renewal does not block sending to ch - the caller must ensure
+// the incoming values are read at proper pace or ch is sufficiently buffered.
+//
+// If ch is nil, the method panics.
+func (a *Auth) OnRenew(ch chan<- TokenRenewal) {
+	if ch == nil {
+		panic("ably: Auth.OnRenew using nil channel")
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.renewals == nil {
+		a.renewals = make(map[chan<- TokenRenewal]struct{})
+	}
+	a.renewals[ch] = struct{}{}
+}
+
+// OffRenew removes ch from listening on background token renewals.
+//
+// If ch was not registered or is already removed, the method is a nop.
+func (a *Auth) OffRenew(ch chan<- TokenRenewal) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	delete(a.renewals, ch)
+}
+
+// scheduleRenewal arms the background renewal of tok, so it is renewed
+// TokenRenewalMargin before it expires. It's a nop if the renewal is not
+// enabled or the token cannot be renewed.
+//
+// It must be called with a.mtx held.
+func (a *Auth) scheduleRenewal(tok *TokenDetails) {
+	a.retries = 0
+	margin := a.opts().TokenRenewalMargin
+	if margin <= 0 || tok.Expires == 0 || !a.isTokenRenewable() || a.stopped {
+		a.stopRenewal()
+		return
+	}
+	a.startRenewal(tok.ExpireTime().Sub(a.serverTime()) - margin)
+}
+
+// startRenewal makes the token to be renewed in background after d.
+//
+// It must be called with a.mtx held.
+func (a *Auth) startRenewal(d time.Duration) {
+	if a.renewal != nil {
+		a.renewal.Stop()
+	}
+	if d < 0 {
+		d = 0
+	}
+	a.renewal = time.AfterFunc(d, a.renew)
+}
+
+// stopRenewal cancels pending background renewal.
+//
+// It must be called with a.mtx held.
+func (a *Auth) stopRenewal() {
+	if a.renewal != nil {
+		a.renewal.Stop()
+		a.renewal = nil
+	}
+}
+
+// stop stops background renewal for good, it's used when the client is closed.
+func (a *Auth) stop() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.stopped = true
+	a.stopRenewal()
+}
+
+// renew requests new token in background. Failed attempts are retried with
+// an exponential backoff up to TokenRenewalMargin, until the current token
+// expires; then the renewal gives up and the token is requested again only
+// when it's needed. On success the token is also passed to the realtime
+// connection, so it's reauthorised before the old token expires.
+func (a *Auth) renew() {
+	a.mtx.Lock()
+	if a.renewal == nil {
+		// Renewal was stopped in the meantime.
+		a.mtx.Unlock()
+		return
+	}
+	tok, err := a.authorise(a.params, nil, true)
+	var renewal TokenRenewal
+	if err != nil {
+		a.retries++
+		renewal.Err = err
+		if tok := a.token(); tok == nil || a.expired(tok) {
+			a.logger().Printf(LogWarning, "Auth: renewing token failed, giving up as the token has expired (attempt=%d): %v", a.retries, err)
+			a.stopRenewal()
+		} else {
+			renewal.RetryIn = retryDelay(a.retries, a.opts().TokenRenewalMargin)
+			a.logger().Printf(LogWarning, "Auth: renewing token failed, retrying in %v (attempt=%d): %v", renewal.RetryIn, a.retries, err)
+			a.startRenewal(renewal.RetryIn)
+		}
+	} else {
+		renewal.Token = tok
+		a.logger().Printf(LogInfo, "Auth: renewed token, expires at %v", tok.ExpireTime())
+	}
+	for ch := range a.renewals {
+		select {
+		case ch <- renewal:
+		default:
+			a.logger().Printf(LogWarning, "Auth: dropping token renewal due to slow receiver")
+		}
+	}
+	sendAuth := a.sendAuth
+	a.mtx.Unlock()
+	if err == nil && sendAuth != nil {
+		if err := sendAuth(tok); err != nil {
+			a.logger().Printf(LogError, "Auth: reauthorising realtime connection failed: %v", err)
+		}
+	}
+}
+
+// checkCapability returns non-nil error if the capability of the current
+// token is known and allows none of the given operations on the channel,
+// so the operation can be rejected without contacting Ably.
+func (a *Auth) checkCapability(channel string, ops ...Operation) error {
+	a.mtx.Lock()
+	tok := a.token()
+	isToken := a.method == authToken
+	a.mtx.Unlock()
+	if !isToken || tok == nil {
+		return nil
+	}
+	c, err := tok.ParseCapability()
+	if err != nil {
+		// Leave it up to Ably to decide.
+		a.logger().Printf(LogWarning, "Auth: unable to check token capability: %v", err)
+		return nil
+	}
+	if c == nil {
+		return nil
+	}
+	for _, op := range ops {
+		if c.Allows(channel, op) {
+			return nil
+		}
+	}
+	return newErrorf(ErrCodeOperationNotPermittedWithProvidedCapability, "token capability does not allow %s on channel %q", ops[0], channel)
+}
+
 func (a *Auth) isTokenRenewable() bool {
 	return a.opts().Key != "" || a.opts().AuthURL != "" || a.opts().AuthCallback != nil
 }
@@ -360,18 +679,59 @@ func (a *Auth) newError(code int, err error) error {
 	return e
 }
 
-func (a *Auth) authReq(req *http.Request) error {
-	switch a.method {
-	case authBasic:
+// authReq authenticates req, giving the token it was authenticated with;
+// the token is nil if basic authentication is used.
+func (a *Auth) authReq(req *http.Request) (*TokenDetails, error) {
+	tok, err := a.acquireToken()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
 		req.SetBasicAuth(a.opts().KeyName(), a.opts().KeySecret())
-	case authToken:
-		if _, err := a.authorise(a.params, nil, false); err != nil {
-			return err
-		}
-		encToken := base64.StdEncoding.EncodeToString([]byte(a.token().Token))
-		req.Header.Set("Authorization", "Bearer "+encToken)
+		return nil, nil
 	}
-	return nil
+	encToken := base64.StdEncoding.EncodeToString([]byte(tok.Token))
+	req.Header.Set("Authorization", "Bearer "+encToken)
+	return tok, nil
+}
+
+// acquireToken gives a valid token for authenticating a request, requesting
+// a new one if the current one has expired; it gives nil token if basic
+// authentication is used instead.
+func (a *Auth) acquireToken() (*TokenDetails, error) {
+	return a.fly(func() (*TokenDetails, error) {
+		a.mtx.Lock()
+		defer a.mtx.Unlock()
+		if a.method != authToken {
+			return nil, nil
+		}
+		return a.authorise(a.params, nil, false)
+	})
+}
+
+// fly runs the token acquisition fn, unless another one is in progress.
+//
+// Concurrent callers share a single acquisition: while one is in progress,
+// the others wait for its outcome, either the token or the error, instead
+// of requesting their own.
+func (a *Auth) fly(fn func() (*TokenDetails, error)) (*TokenDetails, error) {
+	a.flightMtx.Lock()
+	if f := a.flight; f != nil {
+		a.flightMtx.Unlock()
+		<-f.done
+		return f.tok, f.err
+	}
+	f := &tokenFlight{done: make(chan struct{})}
+	a.flight = f
+	a.flightMtx.Unlock()
+
+	f.tok, f.err = fn()
+
+	a.flightMtx.Lock()
+	a.flight = nil
+	a.flightMtx.Unlock()
+	close(f.done)
+	return f.tok, f.err
 }
 
 func (a *Auth) authQuery(query url.Values) error {
@@ -0,0 +1,159 @@
+package ably
+
+// AuthEventType describes what happened to the client's authentication.
+type AuthEventType int
+
+const (
+	// AuthTokenRequested is emitted when the client obtained its first token.
+	AuthTokenRequested AuthEventType = 1 + iota
+
+	// AuthTokenRenewed is emitted when the client obtained a token, which
+	// replaced the previous one, either on request or in background.
+	AuthTokenRenewed
+
+	// AuthTokenRejected is emitted when Ably rejected the current token
+	// or obtaining a new one failed.
+	AuthTokenRejected
+
+	// AuthClientIDChanged is emitted when Auth.ClientID changed, either
+	// due to a new token or when Ably confirmed it for a realtime connection.
+	AuthClientIDChanged
+)
+
+// String implements the fmt.Stringer interface.
+func (t AuthEventType) String() string {
+	switch t {
+	case AuthTokenRequested:
+		return "token requested"
+	case AuthTokenRenewed:
+		return "token renewed"
+	case AuthTokenRejected:
+		return "token rejected"
+	case AuthClientIDChanged:
+		return "clientID changed"
+	default:
+		return "invalid"
+	}
+}
+
+// AuthSource describes where the client's token came from.
+type AuthSource int
+
+const (
+	AuthSourceKey      AuthSource = 1 + iota // token request signed with the key
+	AuthSourceCallback                       // AuthCallback
+	AuthSourceURL                            // AuthURL
+	AuthSourceToken                          // token given explicitly with AuthOptions
+)
+
+// String implements the fmt.Stringer interface.
+func (s AuthSource) String() string {
+	switch s {
+	case AuthSourceKey:
+		return "key"
+	case AuthSourceCallback:
+		return "callback"
+	case AuthSourceURL:
+		return "url"
+	case AuthSourceToken:
+		return "token"
+	default:
+		return "invalid"
+	}
+}
+
+// redactedToken replaces the token string in TokenDetails carried by
+// AuthEvent, so the events can be safely logged.
+const redactedToken = "[redacted]"
+
+// AuthEvent describes a single change of the client's authentication.
+type AuthEvent struct {
+	Type     AuthEventType
+	Source   AuthSource    // source of the token; zero for AuthClientIDChanged
+	Token    *TokenDetails // copy of the token with the token string redacted; may be nil
+	ClientID string        // ClientID of the client after the event
+	Err      error         // reason of AuthTokenRejected; nil otherwise
+}
+
+// OnAuth relays authentication events to the given channel; emitting events
+// does not block sending to ch - the caller must ensure the incoming values
+// are read at proper pace or ch is sufficiently buffered.
+//
+// If ch is nil, the method panics.
+func (a *Auth) OnAuth(ch chan<- AuthEvent) {
+	if ch == nil {
+		panic("ably: Auth.OnAuth using nil channel")
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.events == nil {
+		a.events = make(map[chan<- AuthEvent]struct{})
+	}
+	a.events[ch] = struct{}{}
+}
+
+// OffAuth removes ch from listening on authentication events.
+//
+// If ch was not registered or is already removed, the method is a nop.
+func (a *Auth) OffAuth(ch chan<- AuthEvent) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	delete(a.events, ch)
+}
+
+// emit sends the event to all the listeners, filling ClientID and redacting
+// the token.
+//
+// It must be called with a.mtx held.
+func (a *Auth) emit(ev AuthEvent) {
+	if len(a.events) == 0 {
+		return
+	}
+	if ev.Token != nil {
+		tok := *ev.Token
+		tok.Token = redactedToken
+		ev.Token = &tok
+	}
+	if a.clientID != "*" {
+		ev.ClientID = a.clientID
+	}
+	for ch := range a.events {
+		select {
+		case ch <- ev:
+		default:
+			a.logger().Printf(LogWarning, "Auth: dropping %s event due to slow receiver", ev.Type)
+		}
+	}
+}
+
+// rejected emits AuthTokenRejected for the current token, which Ably
+// refused with the given err, and gives the token.
+func (a *Auth) rejected(err error) *TokenDetails {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	tok := a.token()
+	a.emit(AuthEvent{
+		Type:   AuthTokenRejected,
+		Source: a.tokenSource(nil),
+		Token:  tok,
+		Err:    err,
+	})
+	return tok
+}
+
+// tokenSource gives the source requestToken uses to obtain a token with
+// the given opts.
+func (a *Auth) tokenSource(opts *AuthOptions) AuthSource {
+	switch {
+	case opts != nil && (opts.Token != "" || opts.TokenDetails != nil):
+		return AuthSourceToken
+	case opts != nil && opts.AuthCallback != nil, a.opts().AuthCallback != nil:
+		return AuthSourceCallback
+	case opts != nil && opts.AuthURL != "", a.opts().AuthURL != "":
+		return AuthSourceURL
+	case a.opts().Key != "":
+		return AuthSourceKey
+	default:
+		return AuthSourceToken
+	}
+}
@@ -0,0 +1,152 @@
+package ably
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	errAuthMethod   = errors.New("token requests must be sent with GET or POST method")
+	errAuthClientID = errors.New("requested ClientID is not allowed")
+	errAuthNil      = errors.New("missing Authenticator")
+)
+
+// Authenticator decides whether the given request for a token is allowed.
+// It gives the ClientID and the capability the token is going to be issued
+// for, or a non-nil error which rejects the request.
+//
+// The ClientID may be empty for anonymous clients or a wildcard "*", which
+// lets the client choose the ClientID on its own. If the capability is nil,
+// the token is granted all the capabilities of the key.
+type Authenticator func(req *http.Request) (clientID string, capability Capability, err error)
+
+// TokenRequestHandler is an http.Handler, which serves signed token requests
+// to clients configured with its URL as AuthURL. It accepts both the GET and
+// POST requests, with the TokenParams encoded as query or form values.
+//
+// The token requests are signed with the key from ClientOptions, so the
+// clients do not need to have access to it.
+type TokenRequestHandler struct {
+	MinTTL time.Duration // lower limit of TTL; shorter requested TTLs are extended
+	MaxTTL time.Duration // upper limit of TTL, if non-zero; longer requested TTLs are shortened
+
+	auth         *Auth
+	authenticate Authenticator
+}
+
+// NewTokenRequestHandler creates new handler, which signs the token requests
+// with opts.Key and encodes them with MsgPack or, if opts.NoBinaryProtocol
+// is true, with JSON.
+//
+// Rejected requests are responded with the HTTP status text only; the reasons,
+// including errors returned by the Authenticator, are logged with opts.Logger.
+func NewTokenRequestHandler(opts *ClientOptions, authenticate Authenticator) (*TokenRequestHandler, error) {
+	if authenticate == nil {
+		return nil, newError(40003, errAuthNil)
+	}
+	if opts.KeyName() == "" || opts.KeySecret() == "" {
+		return nil, newError(40101, errMissingKey)
+	}
+	client, err := NewRestClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenRequestHandler{
+		auth:         client.Auth,
+		authenticate: authenticate,
+	}, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *TokenRequestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query, err := tokenQuery(req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	clientID, capability, err := h.authenticate(req)
+	if err != nil {
+		h.writeError(w, newError(40100, err))
+		return
+	}
+	params := h.tokenParams(query)
+	switch {
+	case clientID == "*" && params.ClientID != "":
+	case params.ClientID == "" || params.ClientID == clientID:
+		params.ClientID = clientID
+	default:
+		h.writeError(w, newError(40012, errAuthClientID))
+		return
+	}
+	params.RawCapability = capability.Encode()
+	tokReq, err := h.auth.CreateTokenRequest(params, nil)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	typ := h.auth.opts().protocol()
+	p, err := encode(typ, tokReq)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", typ)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(p)
+}
+
+// tokenParams reads TokenParams requested by the client, which TTL is limited
+// by MinTTL and MaxTTL. The requested capability is ignored, as it's up
+// to the Authenticator.
+func (h *TokenRequestHandler) tokenParams(query url.Values) *TokenParams {
+	params := &TokenParams{
+		ClientID: query.Get("clientId"),
+		TTL:      60 * 60 * 1000,
+	}
+	if n, err := strconv.ParseInt(query.Get("ttl"), 10, 64); err == nil && n > 0 {
+		params.TTL = n
+	}
+	if minTTL := Duration(h.MinTTL); minTTL != 0 && params.TTL < minTTL {
+		params.TTL = minTTL
+	}
+	if maxTTL := Duration(h.MaxTTL); maxTTL != 0 && params.TTL > maxTTL {
+		params.TTL = maxTTL
+	}
+	return params
+}
+
+// tokenQuery gives the values sent by Auth.requestAuthURL.
+func tokenQuery(req *http.Request) (url.Values, error) {
+	switch req.Method {
+	case "GET":
+		return req.URL.Query(), nil
+	case "POST":
+		p, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, newError(40000, err)
+		}
+		query, err := url.ParseQuery(string(p))
+		if err != nil {
+			return nil, newError(40000, err)
+		}
+		return query, nil
+	default:
+		return nil, newError(40500, errAuthMethod)
+	}
+}
+
+// writeError responds with the status of err. The error itself is only logged,
+// as it may expose details of the Authenticator to the client.
+func (h *TokenRequestHandler) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*Error); ok && e.StatusCode != 0 {
+		status = e.StatusCode
+	}
+	h.auth.logger().Printf(LogWarning, "TokenRequestHandler: rejecting token request with status %d: %v", status, err)
+	http.Error(w, http.StatusText(status), status)
+}
@@ -1,13 +1,22 @@
 package ably_test
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -665,3 +674,761 @@ func TestAuth_RealtimeAccessToken(t *testing.T) {
 		}
 	}
 }
+
+func TestAuth_TokenRenewalMargin(t *testing.T) {
+	var mtx sync.Mutex
+	var n int
+	errRenew := errors.New("renewal failed")
+	opts := &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			Key: "fake.key:secret",
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				n++
+				if n > 2 {
+					return nil, errRenew
+				}
+				return &ably.TokenDetails{
+					Token:   fmt.Sprintf("token-%d", n),
+					Expires: ably.Time(time.Now().Add(200 * time.Millisecond)),
+				}, nil
+			},
+		},
+		TokenRenewalMargin: 150 * time.Millisecond,
+	}
+	client, err := ably.NewRestClient(opts)
+	if err != nil {
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	renewals := make(chan ably.TokenRenewal, 1)
+	client.Auth.OnRenew(renewals)
+	if _, err := client.Auth.Authorise(nil, nil); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	next := func() ably.TokenRenewal {
+		select {
+		case renewal := <-renewals:
+			return renewal
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("waiting for token renewal has timed out after %v", ablytest.Timeout)
+			panic("unreachable")
+		}
+	}
+	if renewal := next(); renewal.Err != nil || renewal.Token.Token != "token-2" {
+		t.Fatalf("want token=%q to be renewed; got %+v", "token-2", renewal)
+	}
+	renewal := next()
+	if renewal.Err == nil || renewal.Token != nil {
+		t.Fatalf("want renewal to fail; got %+v", renewal)
+	}
+	if renewal.RetryIn <= 0 {
+		t.Fatalf("want renewal to be retried; got RetryIn=%v", renewal.RetryIn)
+	}
+	// Retries give up once the token has expired.
+	for renewal.RetryIn != 0 {
+		if renewal = next(); renewal.Err == nil {
+			t.Fatalf("want renewal to fail; got %+v", renewal)
+		}
+	}
+	mtx.Lock()
+	calls := n
+	mtx.Unlock()
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if n != calls {
+		t.Fatalf("want no renewals after giving up; got %d", n-calls)
+	}
+	client.Auth.OffRenew(renewals)
+}
+
+func TestAuth_TokenRenewalClose(t *testing.T) {
+	var mtx sync.Mutex
+	var n int
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				n++
+				return &ably.TokenDetails{
+					Token:   fmt.Sprintf("token-%d", n),
+					Expires: ably.Time(time.Now().Add(200 * time.Millisecond)),
+				}, nil
+			},
+		},
+		TokenRenewalMargin: 150 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	if _, err := client.Auth.Authorise(nil, nil); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	// Authorising again after Close does not restart the renewal.
+	if _, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true}); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if n != 2 {
+		t.Fatalf("want no renewals after Close(); got %d", n-2)
+	}
+}
+
+// okTransport is a http.RoundTripper which responds to every request with
+// 200 OK and empty body.
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+func TestAuth_ConcurrentTokenRequests(t *testing.T) {
+	errAuth := errors.New("auth server unavailable")
+	for _, fail := range []bool{false, true} {
+		var mtx sync.Mutex
+		var n int
+		var started sync.WaitGroup
+		client, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{
+				AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+					mtx.Lock()
+					n++
+					mtx.Unlock()
+					// Keep the token request in flight till all the
+					// requests are waiting for it.
+					started.Wait()
+					time.Sleep(50 * time.Millisecond)
+					if fail {
+						return nil, errAuth
+					}
+					return "token", nil
+				},
+			},
+			HTTPClient: &http.Client{Transport: okTransport{}},
+		})
+		if err != nil {
+			t.Fatalf("NewRestClient()=%v", err)
+		}
+		errs := make(chan error, 10)
+		started.Add(cap(errs))
+		for i := 0; i < cap(errs); i++ {
+			go func() {
+				started.Done()
+				_, err := client.Post("/channels/test/messages", nil, nil)
+				errs <- err
+			}()
+		}
+		for i := 0; i < cap(errs); i++ {
+			err := <-errs
+			if fail && ably.ErrorCode(err) != 40170 {
+				t.Errorf("want code=40170; got %d (%v)", ably.ErrorCode(err), err)
+			}
+			if !fail && err != nil {
+				t.Errorf("Post()=%v", err)
+			}
+		}
+		mtx.Lock()
+		if n != 1 {
+			t.Errorf("want AuthCallback to be called once (fail=%t); got %d", fail, n)
+		}
+		mtx.Unlock()
+	}
+}
+
+// rejectTransport is a http.RoundTripper which rejects requests authenticated
+// with the given token with 40140 error, and responds to others like
+// okTransport.
+type rejectTransport struct {
+	token string
+}
+
+func (tr rejectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "Bearer "+base64.StdEncoding.EncodeToString([]byte(tr.token)) {
+		return okTransport{}.RoundTrip(req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"error":{"code":40140,"statusCode":401,"message":"token expired"}}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestAuth_ConcurrentTokenRejections(t *testing.T) {
+	var mtx sync.Mutex
+	var n int
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				n++
+				// Keep the renewal in flight, so the other rejected
+				// requests wait for it.
+				time.Sleep(50 * time.Millisecond)
+				return fmt.Sprintf("token-%d", n), nil
+			},
+		},
+		HTTPClient: &http.Client{Transport: rejectTransport{token: "token-1"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := client.Post("/channels/test/messages", nil, nil)
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Post()=%v", err)
+		}
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	// Once for the initial token, once for renewing the rejected one.
+	if n != 2 {
+		t.Errorf("want AuthCallback to be called twice; got %d", n)
+	}
+}
+
+func TestAuth_JWT(t *testing.T) {
+	issued := time.Now().Truncate(time.Second)
+	params := &ably.TokenParams{
+		TTL:           ably.Duration(time.Hour),
+		RawCapability: `{"foo":["subscribe"]}`,
+		ClientID:      "jwt-client",
+		Timestamp:     ably.Time(issued),
+	}
+	jwt, err := ably.CreateJWT("fake.key:secret", params)
+	if err != nil {
+		t.Fatalf("CreateJWT()=%v", err)
+	}
+	if n := len(strings.Split(jwt, ".")); n != 3 {
+		t.Fatalf("want JWT to have 3 parts; got %d (%q)", n, jwt)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwt")
+		fmt.Fprintln(w, jwt)
+	}))
+	defer srv.Close()
+	callback := func(*ably.TokenParams) (interface{}, error) { return jwt, nil }
+	for _, opts := range []ably.AuthOptions{
+		{AuthCallback: callback},
+		{AuthURL: srv.URL},
+	} {
+		client, err := ably.NewRestClient(&ably.ClientOptions{AuthOptions: opts})
+		if err != nil {
+			t.Fatalf("NewRestClient()=%v", err)
+		}
+		tok, err := client.Auth.Authorise(nil, nil)
+		if err != nil {
+			t.Fatalf("Authorise()=%v", err)
+		}
+		if tok.Token != jwt {
+			t.Errorf("want token=%q; got %q", jwt, tok.Token)
+		}
+		if !tok.IssueTime().Equal(issued) {
+			t.Errorf("want IssueTime()=%v; got %v", issued, tok.IssueTime())
+		}
+		if want := issued.Add(time.Hour); !tok.ExpireTime().Equal(want) {
+			t.Errorf("want ExpireTime()=%v; got %v", want, tok.ExpireTime())
+		}
+		if tok.RawCapability != params.RawCapability {
+			t.Errorf("want capability=%q; got %q", params.RawCapability, tok.RawCapability)
+		}
+		if id := client.Auth.ClientID(); id != "jwt-client" {
+			t.Errorf("want ClientID=%q; got %q", "jwt-client", id)
+		}
+	}
+	if _, err := ably.CreateJWT("invalid", nil); ably.ErrorCode(err) != 40102 {
+		t.Errorf("want code=40102; got %d (%v)", ably.ErrorCode(err), err)
+	}
+}
+
+func TestAuth_TokenRequestHandler(t *testing.T) {
+	var logs bytes.Buffer
+	handler, err := ably.NewTokenRequestHandler(
+		&ably.ClientOptions{
+			AuthOptions:      ably.AuthOptions{Key: "fake.key:secret", UseTokenAuth: true},
+			NoBinaryProtocol: true,
+			Logger:           ably.Logger{Logger: log.New(&logs, "", 0), Level: ably.LogWarning},
+		},
+		func(req *http.Request) (string, ably.Capability, error) {
+			if req.Header.Get("X-User") == "" {
+				return "", nil, errors.New("users db: no row for session")
+			}
+			return req.Header.Get("X-User"), ably.Capability{"chat": {"publish"}}, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewTokenRequestHandler()=%v", err)
+	}
+	handler.MaxTTL = time.Minute
+	for _, method := range []string{"GET", "POST"} {
+		query := url.Values{"ttl": {strconv.FormatInt(ably.Duration(time.Hour), 10)}}
+		var req *http.Request
+		if method == "GET" {
+			req = httptest.NewRequest(method, "/auth?"+query.Encode(), nil)
+		} else {
+			req = httptest.NewRequest(method, "/auth", strings.NewReader(query.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("X-User", "alice")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want code=200; got %d (%s)", method, rec.Code, rec.Body)
+		}
+		if typ := rec.Header().Get("Content-Type"); typ != "application/json" {
+			t.Fatalf("%s: want Content-Type=application/json; got %q", method, typ)
+		}
+		var tokReq ably.TokenRequest
+		if err := json.NewDecoder(rec.Body).Decode(&tokReq); err != nil {
+			t.Fatalf("%s: decoding token request: %v", method, err)
+		}
+		if tokReq.ClientID != "alice" {
+			t.Errorf("%s: want ClientID=%q; got %q", method, "alice", tokReq.ClientID)
+		}
+		if tokReq.KeyName != "fake.key" || tokReq.Mac == "" {
+			t.Errorf("%s: want token request to be signed with fake.key; got %+v", method, tokReq)
+		}
+		if want := ably.Duration(time.Minute); tokReq.TTL != want {
+			t.Errorf("%s: want TTL=%d; got %d", method, want, tokReq.TTL)
+		}
+		if want := `{"chat":["publish"]}`; tokReq.RawCapability != want {
+			t.Errorf("%s: want capability=%q; got %q", method, want, tokReq.RawCapability)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/auth", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("want code=401 for unknown user; got %d", rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "users db") {
+		t.Errorf("want Authenticator error not to be sent to the client; got %q", body)
+	}
+	if !strings.Contains(logs.String(), "users db: no row for session") {
+		t.Errorf("want Authenticator error to be logged; got %q", logs.String())
+	}
+	req := httptest.NewRequest("GET", "/auth?clientId=bob", nil)
+	req.Header.Set("X-User", "alice")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want code=400 for mismatched ClientID; got %d", rec.Code)
+	}
+	_, err = ably.NewTokenRequestHandler(&ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "fake.key:secret"}}, nil)
+	if ably.ErrorCode(err) != 40003 {
+		t.Errorf("want code=40003 for nil Authenticator; got %d (%v)", ably.ErrorCode(err), err)
+	}
+}
+
+func TestAuth_VerifyTokenRequest(t *testing.T) {
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Key: "fake.key:secret"},
+	})
+	if err != nil {
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	newRequest := func(params *ably.TokenParams) *ably.TokenRequest {
+		req, err := client.Auth.CreateTokenRequest(params, nil)
+		if err != nil {
+			t.Fatalf("CreateTokenRequest()=%v", err)
+		}
+		return req
+	}
+	req := newRequest(nil)
+	if err := ably.VerifyTokenRequest(req, "secret"); err != nil {
+		t.Fatalf("VerifyTokenRequest()=%v", err)
+	}
+	if err := ably.VerifyTokenRequest(req, "other-secret"); ably.ErrorCode(err) != ably.ErrCodeInvalidCredentials {
+		t.Errorf("want code=%d for invalid secret; got %d (%v)", ably.ErrCodeInvalidCredentials, ably.ErrorCode(err), err)
+	}
+	forged := *req
+	forged.ClientID = "mallory"
+	if err := ably.VerifyTokenRequest(&forged, "secret"); ably.ErrorCode(err) != ably.ErrCodeInvalidCredentials {
+		t.Errorf("want code=%d for forged request; got %d (%v)", ably.ErrCodeInvalidCredentials, ably.ErrorCode(err), err)
+	}
+	stale := newRequest(&ably.TokenParams{Timestamp: ably.Time(time.Now().Add(-time.Hour))})
+	if err := ably.VerifyTokenRequest(stale, "secret"); ably.ErrorCode(err) != ably.ErrCodeTimestampNotCurrent {
+		t.Errorf("want code=%d for stale request; got %d (%v)", ably.ErrCodeTimestampNotCurrent, ably.ErrorCode(err), err)
+	}
+	verifier := &ably.TokenRequestVerifier{Nonces: ably.NewMemoryNonceStore()}
+	if err := verifier.Verify(req, "secret"); err != nil {
+		t.Fatalf("Verify()=%v", err)
+	}
+	if err := verifier.Verify(req, "secret"); ably.ErrorCode(err) != ably.ErrCodeNonceValueReplayed {
+		t.Errorf("want code=%d for replayed request; got %d (%v)", ably.ErrCodeNonceValueReplayed, ably.ErrorCode(err), err)
+	}
+	if err := verifier.Verify(newRequest(nil), "secret"); err != nil {
+		t.Errorf("Verify()=%v", err)
+	}
+}
+
+func TestAuth_TokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ably-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stores := map[string]ably.TokenStore{
+		"memory": &ably.MemoryTokenStore{},
+		"file":   ably.NewFileTokenStore(filepath.Join(dir, "token.json")),
+	}
+	for name, store := range stores {
+		var n int
+		newClient := func() *ably.RestClient {
+			client, err := ably.NewRestClient(&ably.ClientOptions{
+				AuthOptions: ably.AuthOptions{
+					AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+						n++
+						return &ably.TokenDetails{
+							Token:   fmt.Sprintf("token-%d", n),
+							Expires: ably.Time(time.Now().Add(time.Hour)),
+						}, nil
+					},
+					TokenStore: store,
+				},
+			})
+			if err != nil {
+				t.Fatalf("%s: NewRestClient()=%v", name, err)
+			}
+			return client
+		}
+		tok, err := newClient().Auth.Authorise(nil, nil)
+		if err != nil {
+			t.Fatalf("%s: Authorise()=%v", name, err)
+		}
+		if tok.Token != "token-1" {
+			t.Fatalf("%s: want token=%q; got %q", name, "token-1", tok.Token)
+		}
+		// New client reuses the stored token.
+		if tok, err = newClient().Auth.Authorise(nil, nil); err != nil {
+			t.Fatalf("%s: Authorise()=%v", name, err)
+		}
+		if tok.Token != "token-1" || n != 1 {
+			t.Fatalf("%s: want stored token=%q to be reused; got %q (requested %d tokens)", name, "token-1", tok.Token, n)
+		}
+		// Expired stored token is discarded.
+		if err := store.Save(&ably.TokenDetails{Token: "expired", Expires: ably.Time(time.Now().Add(-time.Minute))}); err != nil {
+			t.Fatalf("%s: Save()=%v", name, err)
+		}
+		if tok, err = newClient().Auth.Authorise(nil, nil); err != nil {
+			t.Fatalf("%s: Authorise()=%v", name, err)
+		}
+		if tok.Token != "token-2" {
+			t.Fatalf("%s: want new token=%q; got %q", name, "token-2", tok.Token)
+		}
+		if saved, err := store.Load(); err != nil || saved.Token != "token-2" {
+			t.Fatalf("%s: want token=%q to be saved; got %v (%v)", name, "token-2", saved, err)
+		}
+	}
+}
+
+// newFakeRestClient gives a client with the fake.key:secret key, which uses
+// token auth and sends every request to the given handler. The returned
+// function stops the handler's server.
+func newFakeRestClient(t *testing.T, handler http.HandlerFunc) (*ably.RestClient, func()) {
+	srv := httptest.NewServer(handler)
+	u, _ := url.Parse(srv.URL)
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Key: "fake.key:secret", UseTokenAuth: true},
+		RestHost:         u.Host,
+		NoTLS:            true,
+		NoBinaryProtocol: true,
+		FallbackHosts:    []string{},
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	return client, srv.Close
+}
+
+func TestAuth_ServerTimeOffset(t *testing.T) {
+	const skew = time.Hour // server clock is ahead of the local one
+	var mtx sync.Mutex
+	var timeRequests int
+	client, closeSrv := newFakeRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		now := time.Now().Add(skew)
+		switch {
+		case r.URL.Path == "/time":
+			mtx.Lock()
+			timeRequests++
+			mtx.Unlock()
+			fmt.Fprintf(w, "[%d]", ably.Time(now))
+		case strings.HasSuffix(r.URL.Path, "/requestToken"):
+			var req ably.TokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			if d := ably.Time(now) - req.Timestamp; d > 60000 || d < -60000 {
+				w.WriteHeader(401)
+				fmt.Fprint(w, `{"error":{"code":40104,"statusCode":401,"message":"timestamp not current"}}`)
+				return
+			}
+			json.NewEncoder(w).Encode(&ably.TokenDetails{
+				Token:   "token",
+				Issued:  ably.Time(now),
+				Expires: ably.Time(now.Add(time.Minute)),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer closeSrv()
+	// The first request is rejected due to the skew, the offset is measured
+	// and the request is signed again with server time.
+	tok, err := client.Auth.Authorise(nil, nil)
+	if err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	// The token would have expired according to local clock, but it's still
+	// valid according to the server one, so it's reused.
+	if tok2, err := client.Auth.Authorise(nil, nil); err != nil || tok2 != tok {
+		t.Fatalf("want token to be reused; got %v (%v)", tok2, err)
+	}
+	if _, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true, UseQueryTime: true}); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if timeRequests != 1 {
+		t.Fatalf("want server time to be queried once; got %d", timeRequests)
+	}
+}
+
+func TestAuth_ServerTimeOffsetFromIssued(t *testing.T) {
+	const skew = 30 * time.Second // within the tolerance of token requests
+	var mtx sync.Mutex
+	var timeRequests int
+	var timestamps []int64
+	client, closeSrv := newFakeRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		now := time.Now().Add(skew)
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch {
+		case r.URL.Path == "/time":
+			timeRequests++
+			fmt.Fprintf(w, "[%d]", ably.Time(now))
+		case strings.HasSuffix(r.URL.Path, "/requestToken"):
+			var req ably.TokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			timestamps = append(timestamps, req.Timestamp)
+			json.NewEncoder(w).Encode(&ably.TokenDetails{
+				Token:   "token",
+				Issued:  ably.Time(now),
+				Expires: ably.Time(now.Add(time.Minute)),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer closeSrv()
+	if _, err := client.Auth.Authorise(nil, nil); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	// The offset taken from the issue time of the first token is used
+	// instead of querying the server time.
+	if _, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true, UseQueryTime: true}); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if timeRequests != 0 {
+		t.Fatalf("want server time not to be queried; got %d requests", timeRequests)
+	}
+	if len(timestamps) != 2 {
+		t.Fatalf("want 2 token requests; got %d", len(timestamps))
+	}
+	if d := timestamps[1] - ably.Time(time.Now().Add(skew)); d > 5000 || d < -5000 {
+		t.Fatalf("want second token request to be signed with server time; got %dms off", d)
+	}
+}
+
+func TestAuth_RevokeTokens(t *testing.T) {
+	var got struct {
+		Targets           []string `json:"targets"`
+		IssuedBefore      int64    `json:"issuedBefore"`
+		AllowReauthMargin bool     `json:"allowReauthMargin"`
+	}
+	var user, pass, path string
+	client, closeSrv := newFakeRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, pass, _ = r.BasicAuth()
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(207)
+		fmt.Fprint(w, `[
+			{"target":"clientId:alice","appliesAt":1500,"issuedBefore":1000},
+			{"target":"revocationKey:","error":{"code":40020,"statusCode":400,"message":"invalid target"}}
+		]`)
+	})
+	defer closeSrv()
+	targets := []ably.TokenRevocationTarget{
+		ably.ClientIDTarget("alice"),
+		ably.RevocationKeyTarget(""),
+	}
+	opts := &ably.TokenRevocationOptions{IssuedBefore: 1000, AllowReauthMargin: true}
+	results, err := client.Auth.RevokeTokens(targets, opts)
+	if err != nil {
+		t.Fatalf("RevokeTokens()=%v", err)
+	}
+	if path != "/keys/fake.key/revokeTokens" {
+		t.Errorf("want path=/keys/fake.key/revokeTokens; got %s", path)
+	}
+	if user != "fake.key" || pass != "secret" {
+		t.Errorf("want request to be authenticated with the key; got %q:%q", user, pass)
+	}
+	wantTargets := []string{"clientId:alice", "revocationKey:"}
+	if !reflect.DeepEqual(got.Targets, wantTargets) || got.IssuedBefore != 1000 || !got.AllowReauthMargin {
+		t.Errorf("unexpected request: %+v", got)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want len(results)=2; got %d", len(results))
+	}
+	if r := results[0]; r.Err != nil || r.Target != "clientId:alice" || r.AppliesAt != 1500 || r.IssuedBefore != 1000 {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	if r := results[1]; r.Target != "revocationKey:" || r.Err == nil {
+		t.Errorf("want result to fail; got %+v", r)
+	} else if e, ok := r.Err.(*ably.Error); !ok || e.Code != 40020 || e.StatusCode != 400 {
+		t.Errorf("want 40020 error; got %#v", r.Err)
+	}
+	if _, err := client.Auth.RevokeTokens(nil, nil); err == nil {
+		t.Error("want RevokeTokens() to fail with no targets")
+	}
+}
+
+func TestAuth_RevokeTokensPartial(t *testing.T) {
+	client, closeSrv := newFakeRestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		fmt.Fprint(w, `{
+			"error":{"code":40020,"statusCode":400,"message":"batched request partially failed"},
+			"batchResponse":[
+				{"target":"clientId:bob","error":{"code":40160,"statusCode":401,"message":"not permitted"}},
+				{"target":"clientId:alice","appliesAt":1500,"issuedBefore":1000}
+			]
+		}`)
+	})
+	defer closeSrv()
+	targets := []ably.TokenRevocationTarget{
+		ably.ClientIDTarget("alice"),
+		ably.ClientIDTarget("bob"),
+		ably.ClientIDTarget("carol"),
+	}
+	results, err := client.Auth.RevokeTokens(targets, nil)
+	if err != nil {
+		t.Fatalf("RevokeTokens()=%v", err)
+	}
+	// There's no result for carol in the response, so it's left out.
+	if len(results) != 2 {
+		t.Fatalf("want len(results)=2; got %d", len(results))
+	}
+	if r := results[0]; r.Err != nil || r.Target != "clientId:alice" || r.AppliesAt != 1500 {
+		t.Errorf("want revocation for alice to succeed; got %+v", r)
+	}
+	if r := results[1]; r.Target != "clientId:bob" || ably.ErrorCode(r.Err) != 40160 {
+		t.Errorf("want revocation for bob to fail with code=40160; got %+v", r)
+	}
+}
+
+func TestAuth_OnAuth(t *testing.T) {
+	var callbackErr error
+	var n int
+	opts := &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				if callbackErr != nil {
+					return nil, callbackErr
+				}
+				n++
+				return &ably.TokenDetails{
+					Token:    "secret-token-" + strconv.Itoa(n),
+					ClientID: "alice",
+					Expires:  ably.Time(time.Now().Add(time.Hour)),
+				}, nil
+			},
+		},
+	}
+	client, err := ably.NewRestClient(opts)
+	if err != nil {
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	events := make(chan ably.AuthEvent, 10)
+	client.Auth.OnAuth(events)
+	if _, err := client.Auth.Authorise(nil, nil); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	if _, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true}); err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	callbackErr = errors.New("logged out")
+	if _, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true}); err == nil {
+		t.Fatal("want Authorise() to fail")
+	}
+	client.Auth.OffAuth(events)
+	callbackErr = nil
+	tok, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true})
+	if err != nil {
+		t.Fatalf("Authorise()=%v", err)
+	}
+	if tok.Token != "secret-token-3" {
+		t.Errorf("want token=secret-token-3; got %q", tok.Token)
+	}
+	close(events)
+	want := []ably.AuthEventType{
+		ably.AuthTokenRequested,
+		ably.AuthClientIDChanged,
+		ably.AuthTokenRenewed,
+		ably.AuthTokenRejected,
+	}
+	var got []ably.AuthEventType
+	for ev := range events {
+		got = append(got, ev.Type)
+		if ev.ClientID != "alice" {
+			t.Errorf("%s: want ClientID=alice; got %q", ev.Type, ev.ClientID)
+		}
+		if ev.Token != nil && strings.Contains(ev.Token.Token, "secret") {
+			t.Errorf("%s: want token to be redacted; got %q", ev.Type, ev.Token.Token)
+		}
+		switch ev.Type {
+		case ably.AuthTokenRequested, ably.AuthTokenRenewed:
+			if ev.Source != ably.AuthSourceCallback || ev.Token == nil {
+				t.Errorf("%s: unexpected event: %+v", ev.Type, ev)
+			}
+		case ably.AuthTokenRejected:
+			if ev.Source != ably.AuthSourceCallback || ev.Err == nil {
+				t.Errorf("%s: unexpected event: %+v", ev.Type, ev)
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want events=%v; got %v", want, got)
+	}
+}
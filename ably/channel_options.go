@@ -0,0 +1,115 @@
+package ably
+
+import (
+	"errors"
+
+	"github.com/ably/ably-go/ably/crypto"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+var errMissingCipher = errors.New("message is encrypted, but the channel has no CipherParams and no cipher is registered for it")
+
+// ChannelOptions configures a channel, either RestChannel or RealtimeChannel.
+type ChannelOptions struct {
+	// Cipher, when non-nil, makes all messages and presence data published
+	// on the channel to be encrypted, and encrypted messages received from
+	// the channel to be decrypted.
+	//
+	// Messages are decoded regardless of the Cipher, so their Data is
+	// a string, []byte or a value decoded from JSON, as it was published.
+	//
+	// Received messages encrypted with a different cipher or key ID, e.g.
+	// published before the key was rotated, are decrypted with the Cipher
+	// registered for their encoding with crypto.RegisterCipher. So are
+	// encrypted messages received while the Cipher is nil.
+	//
+	// A received message, which fails to be decoded, is delivered as is,
+	// with its Encoding still describing the undecoded Data; the failure
+	// is logged and reported with the message's DecodeError.
+	//
+	// Use *crypto.CipherParams for the default AES CBC encryption.
+	Cipher crypto.Cipher
+
+	// Encodings are names of encoders, e.g. "gzip", registered with
+	// proto.RegisterEncoder, which are applied from left to right to Data
+	// of published messages before it's encrypted.
+	//
+	// Received messages are decoded with the registered encoders regardless
+	// of Encodings. A message with an encoding, which has no encoder
+	// registered, is delivered decoded up to that encoding; the failure is
+	// logged as a warning and reported with the message's DecodeError.
+	Encodings []string
+}
+
+// newMessage gives a message to be published with the given extras.
+func newMessage(name string, data interface{}, extras *proto.MessageExtras) (*proto.Message, error) {
+	msg := &proto.Message{Name: name, Data: data}
+	if err := msg.SetExtras(extras); err != nil {
+		return nil, newError(40003, err)
+	}
+	return msg, nil
+}
+
+// encode gives a copy of m with its Data encoded to be sent over binary
+// or JSON transport, and encrypted if a cipher is configured.
+func (opts *ChannelOptions) encode(m *proto.Message, binary bool) (*proto.Message, error) {
+	var c crypto.Cipher
+	var encodings []string
+	if opts != nil {
+		c, encodings = opts.Cipher, opts.Encodings
+	}
+	msg := *m
+	if err := msg.EncodePayload(c, binary, encodings...); err != nil {
+		return nil, newError(40003, err)
+	}
+	return &msg, nil
+}
+
+// encodeAll is like encode, but for a batch of messages.
+func (opts *ChannelOptions) encodeAll(messages []*proto.Message, binary bool) ([]*proto.Message, error) {
+	encoded := make([]*proto.Message, len(messages))
+	for i, m := range messages {
+		msg, err := opts.encode(m, binary)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = msg
+	}
+	return encoded, nil
+}
+
+// decode decodes Data of the message m in place. If decoding fails, m is
+// left untouched, unless the error is *proto.UnknownEncodingError.
+func (opts *ChannelOptions) decode(m *proto.Message) error {
+	if opts != nil && opts.Cipher != nil {
+		return m.DecodeDataWith(opts.Cipher)
+	}
+	err := m.DecodeData(nil)
+	if _, ok := err.(*crypto.UnsupportedCipherError); ok {
+		return errMissingCipher
+	}
+	return err
+}
+
+// decodeAll decodes the given messages or presence messages. The failures
+// are logged and set as DecodeError of the messages.
+func (opts *ChannelOptions) decodeAll(v interface{}, log *Logger) {
+	var messages []*proto.Message
+	switch v := v.(type) {
+	case []*proto.Message:
+		messages = v
+	case []*proto.PresenceMessage:
+		for _, m := range v {
+			messages = append(messages, &m.Message)
+		}
+	}
+	for _, m := range messages {
+		err := opts.decode(m)
+		m.DecodeError = err
+		if _, ok := err.(*proto.UnknownEncodingError); ok {
+			log.Printf(LogWarning, "unable to fully decode message id=%q encoding=%q: %v", m.ID, m.Encoding, err)
+		} else if err != nil {
+			log.Printf(LogError, "unable to decode message id=%q encoding=%q: %v", m.ID, m.Encoding, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Cipher encrypts and decrypts message payloads for a single cipher encoding.
+type Cipher interface {
+	// Encoding gives the cipher part of the message encoding, e.g.
+	// "cipher+aes-256-gcm:key-2", which is used to find the Cipher
+	// for decrypting the payload with NewCipher.
+	Encoding() string
+
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// CipherFunc gives a Cipher for the given key ID, which is empty if
+// the encoding has none. The key is the one the message is being decoded
+// with, if any; ciphers, which manage keys on their own, e.g. with a KMS,
+// may ignore it and look up the key by its ID instead.
+type CipherFunc func(keyID string, key []byte) (Cipher, error)
+
+var ciphers = struct {
+	sync.RWMutex
+	m map[string]CipherFunc
+}{m: make(map[string]CipherFunc)}
+
+func init() {
+	for _, bits := range []int{128, 256} {
+		name := fmt.Sprintf("%s-%d-%s", AlgorithmAES, bits, ModeCBC)
+		RegisterCipher(name, func(keyID string, key []byte) (Cipher, error) {
+			return ParseEncoding(JoinEncoding(name, keyID), key)
+		})
+	}
+}
+
+// UnsupportedCipherError is returned by NewCipher when no CipherFunc is
+// registered for the cipher of the encoding.
+type UnsupportedCipherError struct {
+	Encoding string
+}
+
+func (err *UnsupportedCipherError) Error() string {
+	return fmt.Sprintf("unsupported cipher encoding %q", err.Encoding)
+}
+
+// RegisterCipher makes fn to be used for payloads encrypted with the cipher
+// of the given name, e.g. "aes-256-gcm" for "cipher+aes-256-gcm" encoding.
+// Registering a cipher under the name of an already registered one,
+// including the default "aes-128-cbc" and "aes-256-cbc", replaces it.
+//
+// If fn is nil or name is invalid, the function panics.
+func RegisterCipher(name string, fn CipherFunc) {
+	if fn == nil {
+		panic("crypto: RegisterCipher using nil CipherFunc")
+	}
+	if name == "" || strings.ContainsAny(name, ":/") {
+		panic("crypto: RegisterCipher using invalid name " + name)
+	}
+	ciphers.Lock()
+	defer ciphers.Unlock()
+	ciphers.m[name] = fn
+}
+
+// NewCipher gives a Cipher for the cipher part of the message encoding,
+// e.g. "cipher+aes-128-cbc", using the registered CipherFunc. If there's
+// none, *UnsupportedCipherError is returned.
+func NewCipher(encoding string, key []byte) (Cipher, error) {
+	name, keyID, err := SplitEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	ciphers.RLock()
+	fn, ok := ciphers.m[name]
+	ciphers.RUnlock()
+	if !ok {
+		return nil, &UnsupportedCipherError{Encoding: encoding}
+	}
+	return fn(keyID, key)
+}
+
+// SplitEncoding splits the cipher part of the message encoding into
+// the cipher name and the optional key ID, e.g. "cipher+aes-256-gcm:key-2"
+// into "aes-256-gcm" and "key-2".
+func SplitEncoding(encoding string) (name, keyID string, err error) {
+	if !strings.HasPrefix(encoding, "cipher+") {
+		return "", "", fmt.Errorf("invalid cipher encoding %q", encoding)
+	}
+	name = strings.TrimPrefix(encoding, "cipher+")
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		name, keyID = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid cipher encoding %q", encoding)
+	}
+	return name, keyID, nil
+}
+
+// JoinEncoding is the inverse of SplitEncoding.
+func JoinEncoding(name, keyID string) string {
+	if keyID == "" {
+		return "cipher+" + name
+	}
+	return "cipher+" + name + ":" + keyID
+}
@@ -0,0 +1,123 @@
+package crypto_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ably/ably-go/ably/crypto"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// gcmCipher is an example AES-GCM cipher with keys looked up by their IDs
+// in keyring, as it would be with a KMS.
+type gcmCipher struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+const gcmName = "test-aes-256-gcm"
+
+var keyring = map[string][]byte{}
+
+func newGCMCipher(keyID string, _ []byte) (crypto.Cipher, error) {
+	key, ok := keyring[keyID]
+	if !ok {
+		return nil, errors.New("unknown key " + keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmCipher{keyID: keyID, aead: aead}, nil
+}
+
+func (c *gcmCipher) Encoding() string {
+	return crypto.JoinEncoding(gcmName, c.keyID)
+}
+
+func (c *gcmCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *gcmCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	return c.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+func TestRegisterCipher(t *testing.T) {
+	for _, id := range []string{"key-1", "key-2"} {
+		key, err := crypto.GenerateRandomKey(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyring[id] = key
+	}
+	crypto.RegisterCipher(gcmName, newGCMCipher)
+	old, err := crypto.NewCipher("cipher+"+gcmName+":key-1", nil)
+	if err != nil {
+		t.Fatalf("NewCipher()=%v", err)
+	}
+	msg := &proto.Message{Data: "secret data", Encoding: proto.UTF8}
+	if err := msg.EncryptWith(old); err != nil {
+		t.Fatalf("EncryptWith()=%v", err)
+	}
+	if want := "utf-8/cipher+" + gcmName + ":key-1"; msg.Encoding != want {
+		t.Fatalf("want encoding=%q; got %q", want, msg.Encoding)
+	}
+	// The key was rotated, the message must be decrypted with the old one.
+	current, err := newGCMCipher("key-2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.DecodeDataWith(current); err != nil {
+		t.Fatalf("DecodeDataWith()=%v", err)
+	}
+	if msg.Data != "secret data" {
+		t.Fatalf("want data=%q; got %q", "secret data", msg.Data)
+	}
+	if _, err := crypto.NewCipher("cipher+"+gcmName+":key-3", nil); err == nil {
+		t.Error("want NewCipher() to fail for unknown key")
+	}
+	if _, err := crypto.NewCipher("cipher+unknown-256-gcm", nil); err == nil {
+		t.Error("want NewCipher() to fail for unregistered cipher")
+	} else if _, ok := err.(*crypto.UnsupportedCipherError); !ok {
+		t.Errorf("want *UnsupportedCipherError; got %#v", err)
+	}
+}
+
+func TestNewCipher_KeyID(t *testing.T) {
+	key := make([]byte, 16)
+	c, err := crypto.NewCipher("cipher+aes-128-cbc:key-1", key)
+	if err != nil {
+		t.Fatalf("NewCipher()=%v", err)
+	}
+	params, ok := c.(*crypto.CipherParams)
+	if !ok {
+		t.Fatalf("want *crypto.CipherParams; got %T", c)
+	}
+	if params.KeyID != "key-1" || params.Encoding() != "cipher+aes-128-cbc:key-1" {
+		t.Errorf("unexpected params: KeyID=%q Encoding()=%q", params.KeyID, params.Encoding())
+	}
+	msg := &proto.Message{Data: "data"}
+	if err := msg.EncodeData("utf-8/cipher+aes-128-cbc:key-1/base64", key, nil); err != nil {
+		t.Fatalf("EncodeData()=%v", err)
+	}
+	if err := msg.DecodeData(key); err != nil || msg.Data != "data" {
+		t.Fatalf("want data=%q; got %q (%v)", "data", msg.Data, err)
+	}
+}
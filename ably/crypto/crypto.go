@@ -0,0 +1,219 @@
+// Package crypto implements encryption of message payloads, as described
+// by the cipher part of the message encoding, e.g. "cipher+aes-256-cbc".
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Supported algorithms and modes.
+const (
+	AlgorithmAES = "aes"
+	ModeCBC      = "cbc"
+)
+
+// DefaultKeyLength is the length of keys generated by GenerateRandomKey,
+// in bits, when no length is requested.
+const DefaultKeyLength = 256
+
+var (
+	errCiphertextLength = errors.New("ciphertext is not a multiple of the block size")
+	errInvalidPadding   = errors.New("invalid padding")
+)
+
+// CipherParams describes how message payloads are encrypted.
+type CipherParams struct {
+	Algorithm string // name of the algorithm; only "aes" is supported
+	Mode      string // mode of operation; only "cbc" is supported
+	KeyLength int    // length of the key in bits; either 128 or 256
+	Key       []byte // secret key
+	KeyID     string // optional ID of the key, carried in the encoding
+
+	// IV, when non-empty, is used as the initialization vector instead
+	// of a random one. It should be set only for testing, as reusing
+	// the same IV weakens the encryption.
+	IV []byte
+}
+
+// DefaultCipherParams gives AES CBC params for the given key, which
+// length must be either 128 or 256 bits.
+func DefaultCipherParams(key []byte) (*CipherParams, error) {
+	p := &CipherParams{
+		Algorithm: AlgorithmAES,
+		Mode:      ModeCBC,
+		KeyLength: len(key) * 8,
+		Key:       key,
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseEncoding gives CipherParams for the given key and the cipher part
+// of the message encoding, e.g. "cipher+aes-128-cbc" or, with a key ID,
+// "cipher+aes-128-cbc:key-1".
+func ParseEncoding(encoding string, key []byte) (*CipherParams, error) {
+	name, keyID, err := SplitEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(name, "-")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid cipher encoding %q", encoding)
+	}
+	keyLen, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key length in cipher encoding %q", encoding)
+	}
+	p := &CipherParams{
+		Algorithm: parts[0],
+		Mode:      parts[2],
+		KeyLength: keyLen,
+		Key:       key,
+		KeyID:     keyID,
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GenerateRandomKey gives a new random key of the given length in bits,
+// which must be either 128 or 256. If bits is 0, DefaultKeyLength is used.
+func GenerateRandomKey(bits int) ([]byte, error) {
+	if bits == 0 {
+		bits = DefaultKeyLength
+	}
+	if err := validateKeyLength(bits); err != nil {
+		return nil, err
+	}
+	key := make([]byte, bits/8)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeKey decodes the key from its base64 representation, either
+// the standard or the URL-safe one, with or without padding.
+func DecodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("-", "+", "_", "/").Replace(s)
+	key, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateKeyLength(len(key) * 8); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Validate returns non-nil error if the params describe unsupported
+// algorithm or mode, or the key does not match the key length.
+func (p *CipherParams) Validate() error {
+	switch {
+	case p.Algorithm != AlgorithmAES:
+		return fmt.Errorf("unsupported cipher algorithm %q", p.Algorithm)
+	case p.Mode != ModeCBC:
+		return fmt.Errorf("unsupported cipher mode %q", p.Mode)
+	case len(p.Key)*8 != p.KeyLength:
+		return fmt.Errorf("key is %d bits long, want %d", len(p.Key)*8, p.KeyLength)
+	case strings.Contains(p.KeyID, "/"):
+		return fmt.Errorf("key ID %q must not contain a slash", p.KeyID)
+	case len(p.IV) != 0 && len(p.IV) != aes.BlockSize:
+		return fmt.Errorf("IV is %d bytes long, want %d", len(p.IV), aes.BlockSize)
+	}
+	return validateKeyLength(p.KeyLength)
+}
+
+// Encoding implements the Cipher interface. It gives the cipher part
+// of the message encoding, e.g. "cipher+aes-256-cbc".
+func (p *CipherParams) Encoding() string {
+	return JoinEncoding(fmt.Sprintf("%s-%d-%s", p.Algorithm, p.KeyLength, p.Mode), p.KeyID)
+}
+
+// Encrypt implements the Cipher interface. It pads and encrypts
+// the plaintext. The returned ciphertext is prefixed with the IV.
+func (p *CipherParams) Encrypt(plaintext []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	data := pkcs7Pad(plaintext, aes.BlockSize)
+	out := make([]byte, aes.BlockSize+len(data))
+	iv := out[:aes.BlockSize]
+	if len(p.IV) != 0 {
+		copy(iv, p.IV)
+	} else if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[aes.BlockSize:], data)
+	return out, nil
+}
+
+// Decrypt implements the Cipher interface. It decrypts the ciphertext,
+// which is prefixed with the IV, and removes the padding.
+func (p *CipherParams) Decrypt(ciphertext []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(p.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errCiphertextLength
+	}
+	iv, data := ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	return pkcs7Unpad(out, aes.BlockSize)
+}
+
+func validateKeyLength(bits int) error {
+	switch bits {
+	case 128, 256:
+		return nil
+	default:
+		return fmt.Errorf("unsupported key length %d, want 128 or 256 bits", bits)
+	}
+}
+
+// pkcs7Pad appends padding to data, so its length is a multiple
+// of blocklen. Padding is always appended, even if data is already
+// of the right length.
+func pkcs7Pad(data []byte, blocklen int) []byte {
+	n := blocklen - len(data)%blocklen
+	return append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+// pkcs7Unpad gives data without the padding.
+func pkcs7Unpad(data []byte, blocklen int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blocklen != 0 {
+		return nil, errInvalidPadding
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > blocklen {
+		return nil, errInvalidPadding
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errInvalidPadding
+		}
+	}
+	return data[:len(data)-n], nil
+}
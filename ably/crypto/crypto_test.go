@@ -0,0 +1,146 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/crypto"
+)
+
+func TestCipherParams_EncryptDecrypt(t *testing.T) {
+	for _, bits := range []int{128, 256} {
+		key, err := crypto.GenerateRandomKey(bits)
+		if err != nil {
+			t.Fatalf("GenerateRandomKey(%d)=%v", bits, err)
+		}
+		if len(key)*8 != bits {
+			t.Fatalf("want %d bit key; got %d", bits, len(key)*8)
+		}
+		params, err := crypto.DefaultCipherParams(key)
+		if err != nil {
+			t.Fatalf("DefaultCipherParams()=%v", err)
+		}
+		for _, plaintext := range []string{"", "data", "0123456789abcde\x01", "0123456789abcdef"} {
+			c1, err := params.Encrypt([]byte(plaintext))
+			if err != nil {
+				t.Fatalf("Encrypt(%q)=%v", plaintext, err)
+			}
+			c2, err := params.Encrypt([]byte(plaintext))
+			if err != nil {
+				t.Fatalf("Encrypt(%q)=%v", plaintext, err)
+			}
+			if bytes.Equal(c1[:16], c2[:16]) {
+				t.Errorf("want random IV for each encryption; got %x twice", c1[:16])
+			}
+			p, err := params.Decrypt(c1)
+			if err != nil {
+				t.Fatalf("Decrypt()=%v", err)
+			}
+			if string(p) != plaintext {
+				t.Errorf("want plaintext=%q; got %q", plaintext, p)
+			}
+		}
+	}
+}
+
+func TestCipherParams_FixedIV(t *testing.T) {
+	key, _ := crypto.DecodeKey("WUP6u0K7MXI5Zeo0VppPwg==")
+	params, err := crypto.DefaultCipherParams(key)
+	if err != nil {
+		t.Fatalf("DefaultCipherParams()=%v", err)
+	}
+	params.IV, _ = base64.StdEncoding.DecodeString("HO4cYSP8LybPYBPZPHQOtg==")
+	c, err := params.Encrypt([]byte("The quick brown fox jumped over the lazy dog"))
+	if err != nil {
+		t.Fatalf("Encrypt()=%v", err)
+	}
+	const want = "HO4cYSP8LybPYBPZPHQOtmHItcxYdSvcNUC6kXVpMn0VFL+9z2/5tJ6WFbR0SBT1xhFRuJ+MeBGTU3yOY9P5ow=="
+	if got := base64.StdEncoding.EncodeToString(c); got != want {
+		t.Errorf("want ciphertext=%s; got %s", want, got)
+	}
+	if params.Encoding() != "cipher+aes-128-cbc" {
+		t.Errorf("want encoding=cipher+aes-128-cbc; got %s", params.Encoding())
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := crypto.ParseEncoding("cipher+aes-256-cbc", key); err != nil {
+		t.Errorf("ParseEncoding()=%v", err)
+	}
+	for _, enc := range []string{
+		"cipher+aes-128-cbc", // mismatched key length
+		"cipher+aes",
+		"cipher+aes-256",
+		"cipher+des-256-cbc",
+		"cipher+aes-256-ecb",
+		"cipher+aes-x-cbc",
+		"aes-256-cbc",
+		"",
+	} {
+		if _, err := crypto.ParseEncoding(enc, key); err == nil {
+			t.Errorf("ParseEncoding(%q): want error", enc)
+		}
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key, _ := crypto.GenerateRandomKey(0)
+	if len(key) != 32 {
+		t.Fatalf("want 256 bit key by default; got %d", len(key)*8)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		got, err := crypto.DecodeKey(enc.EncodeToString(key))
+		if err != nil {
+			t.Fatalf("DecodeKey()=%v", err)
+		}
+		if !bytes.Equal(got, key) {
+			t.Errorf("want key=%x; got %x", key, got)
+		}
+	}
+	if _, err := crypto.DecodeKey(base64.StdEncoding.EncodeToString(key[:10])); err == nil {
+		t.Error("want DecodeKey() to fail for 80 bit key")
+	}
+	if _, err := crypto.GenerateRandomKey(64); err == nil {
+		t.Error("want GenerateRandomKey(64) to fail")
+	}
+}
+
+func TestCipherParams_Fixtures(t *testing.T) {
+	for _, fixture := range []string{
+		"test-resources/crypto-data-128.json",
+		"test-resources/crypto-data-256.json",
+	} {
+		test, key, iv, err := ablytest.LoadCryptoData(fixture)
+		if err != nil {
+			// Fall back to the subset of the fixtures kept in testdata.
+			fixture = filepath.Join("testdata", path.Base(fixture))
+			if test, key, iv, err = ablytest.LoadCryptoDataFile(fixture); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, item := range test.Items {
+			if err := item.Encoded.DecodeData(nil); err != nil {
+				t.Fatalf("%s: DecodeData()=%v", fixture, err)
+			}
+			encrypted := item.Encoded
+			if err := encrypted.EncodeData(item.Encrypted.Encoding, key, iv); err != nil {
+				t.Fatalf("%s: EncodeData()=%v", fixture, err)
+			}
+			if !reflect.DeepEqual(encrypted.Data, item.Encrypted.Data) {
+				t.Errorf("%s: want data=%q; got %q", fixture, item.Encrypted.Data, encrypted.Data)
+			}
+			if err := item.Encrypted.DecodeData(key); err != nil {
+				t.Fatalf("%s: DecodeData()=%v", fixture, err)
+			}
+			if !reflect.DeepEqual(item.Encrypted.Data, item.Encoded.Data) {
+				t.Errorf("%s: want data=%q; got %q", fixture, item.Encoded.Data, item.Encrypted.Data)
+			}
+		}
+	}
+}
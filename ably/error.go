@@ -103,16 +103,23 @@ func checkValidHTTPResponse(resp *http.Response) error {
 			Err:        genericError(errors.New(http.StatusText(resp.StatusCode))),
 		}
 	}
+	return errorFromProto(&body.Error, resp.StatusCode)
+}
+
+// errorFromProto converts the error received from Ably to *Error. If the error
+// has neither code nor status code, they're derived from the HTTP status
+// the error was received with.
+func errorFromProto(e *proto.Error, status int) *Error {
 	err := &Error{
-		Code:       body.Error.Code,
-		StatusCode: body.Error.StatusCode,
-		Server:     body.Error.Server,
+		Code:       e.Code,
+		StatusCode: e.StatusCode,
+		Server:     e.Server,
 	}
-	if body.Error.Message != "" {
-		err.Err = errors.New(body.Error.Message)
+	if e.Message != "" {
+		err.Err = errors.New(e.Message)
 	}
 	if err.Code == 0 && err.StatusCode == 0 {
-		err.Code, err.StatusCode = resp.StatusCode*100, resp.StatusCode
+		err.Code, err.StatusCode = status*100, status
 	}
 	return err
 }
@@ -139,6 +146,8 @@ const (
 	ErrCodeInvalidCredentials                          = 40101
 	ErrCodeIncompatibleCredentials                     = 40102
 	ErrCodeInvalidUseOfBasicAuthOverNonTLSTransport    = 40103
+	ErrCodeTimestampNotCurrent                         = 40104
+	ErrCodeNonceValueReplayed                          = 40105
 	ErrCodeAccountDisabled                             = 40110
 	ErrCodeAccountBlockedConnectionLimitsExceeded      = 40111
 	ErrCodeAccountBlockedMessageLimitsExceeded         = 40112
@@ -196,6 +205,7 @@ const (
 	ErrCodeConnectionTimedOut                                     = 80014
 	ErrCodeIncompatibleConnectionParameters                       = 80015
 	ErrCodeOperationOnSupersededTransport                         = 80016
+	ErrCodeConnectionClosed                                       = 80017
 
 	// error codes for channel failures
 	ErrCodeChannelOperationFailed                           = 90000
@@ -227,6 +237,8 @@ var errCodeText = map[int]string{
 	ErrCodeInvalidCredentials:                                           "invalid credentials",
 	ErrCodeIncompatibleCredentials:                                      "incompatible credentials",
 	ErrCodeInvalidUseOfBasicAuthOverNonTLSTransport:                     "invalid use of Basic auth over non-TLS transport",
+	ErrCodeTimestampNotCurrent:                                          "timestamp not current",
+	ErrCodeNonceValueReplayed:                                           "nonce value replayed",
 	ErrCodeAccountDisabled:                                              "account disabled",
 	ErrCodeAccountBlockedConnectionLimitsExceeded:                       "account blocked (connection limits exceeded)",
 	ErrCodeAccountBlockedMessageLimitsExceeded:                          "account blocked (message limits exceeded)",
@@ -25,7 +25,7 @@ func Merge(orig, v interface{}, defaults bool) {
 		switch field.Type().Kind() {
 		case reflect.Struct:
 			empty = true // TODO: merge structs recursively
-		case reflect.Chan, reflect.Func, reflect.Slice, reflect.Map:
+		case reflect.Chan, reflect.Func, reflect.Slice, reflect.Map, reflect.Interface:
 			empty = field.IsNil()
 		default:
 			empty = field.Interface() == reflect.Zero(field.Type()).Interface()
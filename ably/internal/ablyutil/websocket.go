@@ -1,8 +1,11 @@
 package ablyutil
 
 import (
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/url"
+	"time"
 
 	"github.com/ably/ably-go/ably/proto"
 
@@ -32,7 +35,10 @@ func (ws *WebsocketConn) Close() error {
 	return ws.conn.Close()
 }
 
-func DialWebsocket(proto string, u *url.URL) (*WebsocketConn, error) {
+// DialWebsocket opens websocket connection to the given URL. Dialing fails
+// if establishing the connection, including the websocket handshake, takes
+// longer than timeout. Zero timeout means no timeout.
+func DialWebsocket(proto string, u *url.URL, timeout time.Duration) (*WebsocketConn, error) {
 	ws := &WebsocketConn{}
 	switch proto {
 	case "application/json":
@@ -42,7 +48,11 @@ func DialWebsocket(proto string, u *url.URL) (*WebsocketConn, error) {
 	default:
 		return nil, errors.New(`invalid protocol "` + proto + `"`)
 	}
-	conn, err := websocket.Dial(u.String(), "", "https://"+u.Host)
+	config, err := websocket.NewConfig(u.String(), "https://"+u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialWebsocket(config, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +60,52 @@ func DialWebsocket(proto string, u *url.URL) (*WebsocketConn, error) {
 	return ws, nil
 }
 
+var portMap = map[string]string{
+	"ws":  "80",
+	"wss": "443",
+}
+
+// dialWebsocket is like websocket.DialConfig, but it dials with timeout.
+func dialWebsocket(config *websocket.Config, timeout time.Duration) (*websocket.Conn, error) {
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	addr := config.Location.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, portMap[config.Location.Scheme])
+	}
+	var conn net.Conn
+	var err error
+	switch config.Location.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", addr)
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config.TlsConfig)
+	default:
+		err = websocket.ErrBadScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Deadline guards the websocket handshake, it's reset once it's done.
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
 var msgpackCodec = websocket.Codec{
 	Marshal: func(v interface{}) ([]byte, byte, error) {
 		p, err := msgpack.Marshal(v)
@@ -0,0 +1,101 @@
+package ably
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var errInvalidJWT = errors.New("invalid JWT token")
+
+type jwtHeader struct {
+	Type      string `json:"typ,omitempty"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type jwtClaims struct {
+	IssuedAt   int64  `json:"iat,omitempty"` // in seconds since epoch
+	Expires    int64  `json:"exp,omitempty"` // in seconds since epoch
+	Capability string `json:"x-ably-capability,omitempty"`
+	ClientID   string `json:"x-ably-clientId,omitempty"`
+}
+
+// CreateJWT creates an Ably JWT token signed with the given key for
+// the given params. It's meant to be used on a server, which hands out
+// the tokens to clients via AuthURL or AuthCallback, so they do not need
+// to have access to the key.
+//
+// If params is nil or its fields are zero, the token is issued now for one
+// hour with all capabilities.
+func CreateJWT(key string, params *TokenParams) (string, error) {
+	opts := &AuthOptions{Key: key}
+	keyName, keySecret := opts.KeyName(), opts.KeySecret()
+	if keyName == "" || keySecret == "" {
+		return "", newError(40102, errInvalidKey)
+	}
+	var p TokenParams
+	if params != nil {
+		p = *params
+	}
+	if p.Timestamp == 0 {
+		p.Timestamp = TimeNow()
+	}
+	if p.TTL == 0 {
+		p.TTL = 60 * 60 * 1000
+	}
+	if p.RawCapability == "" {
+		p.RawCapability = (Capability{"*": {"*"}}).Encode()
+	}
+	header, err := json.Marshal(jwtHeader{Type: "JWT", Algorithm: "HS256", KeyID: keyName})
+	if err != nil {
+		return "", newError(40000, err)
+	}
+	claims, err := json.Marshal(jwtClaims{
+		IssuedAt:   p.Timestamp / 1000,
+		Expires:    (p.Timestamp + p.TTL) / 1000,
+		Capability: p.RawCapability,
+		ClientID:   p.ClientID,
+	})
+	if err != nil {
+		return "", newError(40000, err)
+	}
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	mac := hmac.New(sha256.New, []byte(keySecret))
+	mac.Write([]byte(token))
+	return token + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseJWT decodes the claims of a JWT token into tok. The signature is not
+// verified, it's the job of Ably servers.
+func parseJWT(token string, tok *TokenDetails) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errInvalidJWT
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Algorithm == "" {
+		return errInvalidJWT
+	}
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return errInvalidJWT
+	}
+	tok.Issued = claims.IssuedAt * 1000
+	tok.Expires = claims.Expires * 1000
+	tok.RawCapability = claims.Capability
+	tok.ClientID = claims.ClientID
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
@@ -2,6 +2,7 @@ package ably
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -16,6 +17,7 @@ import (
 const (
 	protocolJSON    = "application/json"
 	protocolMsgPack = "application/x-msgpack"
+	protocolJWT     = "application/jwt"
 )
 
 var defaultOptions = &ClientOptions{
@@ -24,6 +26,17 @@ var defaultOptions = &ClientOptions{
 	TimeoutConnect:    15 * time.Second,
 	TimeoutDisconnect: 30 * time.Second,
 	TimeoutSuspended:  2 * time.Minute,
+	TimeoutRequest:    10 * time.Second,
+	TimeoutIdleGrace:  10 * time.Second,
+	HTTPMaxRetryCount: 3,
+}
+
+var defaultFallbackHosts = []string{
+	"a.ably-realtime.com",
+	"b.ably-realtime.com",
+	"c.ably-realtime.com",
+	"d.ably-realtime.com",
+	"e.ably-realtime.com",
 }
 
 const (
@@ -40,7 +53,8 @@ type AuthOptions struct {
 	// The returned value of the token is expected to be one of the following
 	// types:
 	//
-	//   - string, which is then used as token string
+	//   - string, which is then used as token string; if it's an Ably JWT,
+	//     its expiry time, capability and ClientID are read from its claims
 	//   - *ably.TokenRequest, which is then used as an already signed request
 	//   - *ably.TokenDetails, which is then used as a token
 	//
@@ -55,8 +69,8 @@ type AuthOptions struct {
 	// builds a req (*http.Request) which then is issued against the given AuthURL
 	// in order to obtain authentication token. The response is expected to
 	// carry a single token string in the payload when Content-Type header
-	// is "text/plain" or JSON-encoded *ably.TokenDetails when the header
-	// is "application/json".
+	// is "text/plain" or "application/jwt", or JSON-encoded *ably.TokenDetails
+	// when the header is "application/json".
 	//
 	// The req is built with the following values:
 	//
@@ -106,6 +120,11 @@ type AuthOptions struct {
 	// authentication method.
 	UseTokenAuth bool
 
+	// TokenStore, when non-nil, persists every new token obtained by
+	// the client. A valid token loaded from it on start is used instead
+	// of requesting a new one; an expired one is discarded.
+	TokenStore TokenStore
+
 	// Force when true makes the client request new token unconditionally.
 	//
 	// By default the client does not request new token if the current one
@@ -152,10 +171,23 @@ type ClientOptions struct {
 	RealtimeHost    string // optional; overwrite endpoint hostname for Realtime client
 	Environment     string // optional; prefixes both hostname with the environment string
 	ClientID        string // optional; required for managing realtime presence of the current client
-	Recover         string // optional; used to recover client state
+	Recover         string // optional; key obtained from (*Conn).RecoveryKey, used to recover connection state
 	Logger          Logger // optional; overwrite logging defaults
 	TransportParams map[string]string
 
+	// FallbackHosts are tried in random order when the primary REST or realtime
+	// host is unreachable or responds with a server error.
+	//
+	// If FallbackHosts is nil, a default list of hosts is used, prefixed with
+	// Environment if it is set. The default list is not used for REST requests
+	// if RestHost is set, nor for realtime connections if RealtimeHost is set.
+	// Setting FallbackHosts to an empty, non-nil slice disables fallback.
+	FallbackHosts []string
+
+	// HTTPMaxRetryCount limits the number of fallback hosts a failed REST
+	// request is retried with. If zero, 3 is used.
+	HTTPMaxRetryCount int
+
 	NoTLS            bool // when true REST and realtime client won't use TLS
 	NoConnect        bool // when true realtime client will not attempt to connect automatically
 	NoEcho           bool // when true published messages will not be echoed back
@@ -165,6 +197,19 @@ type ClientOptions struct {
 	TimeoutConnect    time.Duration // time period after which connect request is failed
 	TimeoutDisconnect time.Duration // time period after which disconnect request is failed
 	TimeoutSuspended  time.Duration // time period after which no more reconnection attempts are performed
+	TimeoutRequest    time.Duration // time period after which realtime request awaiting response is failed
+	TimeoutIdleGrace  time.Duration // time period on top of Ably's max idle interval after which idle connection is considered lost
+
+	// TokenRenewalMargin enables renewing the token in background, so it's
+	// done this long before the token expires. The outcomes of renewals are
+	// relayed to channels registered with Auth.OnRenew.
+	//
+	// If TokenRenewalMargin is zero, the token is renewed only once
+	// it has expired. It has no effect if the token is not renewable.
+	//
+	// Background renewal is stopped by closing the client with either
+	// RestClient.Close or RealtimeClient.Close.
+	TokenRenewalMargin time.Duration
 
 	// Dial specifies the dial function for creating message connections used
 	// by RealtimeClient.
@@ -212,14 +257,52 @@ func (opts *ClientOptions) timeoutSuspended() time.Duration {
 	return defaultOptions.TimeoutSuspended
 }
 
-func (opts *ClientOptions) restURL() string {
-	host := opts.RestHost
-	if host == "" {
-		host = defaultOptions.RestHost
-		if opts.Environment != "" {
-			host = opts.Environment + "-" + host
-		}
+func (opts *ClientOptions) timeoutRequest() time.Duration {
+	if opts.TimeoutRequest != 0 {
+		return opts.TimeoutRequest
+	}
+	return defaultOptions.TimeoutRequest
+}
+
+func (opts *ClientOptions) timeoutIdleGrace() time.Duration {
+	if opts.TimeoutIdleGrace != 0 {
+		return opts.TimeoutIdleGrace
+	}
+	return defaultOptions.TimeoutIdleGrace
+}
+
+func (opts *ClientOptions) httpMaxRetryCount() int {
+	if opts.HTTPMaxRetryCount != 0 {
+		return opts.HTTPMaxRetryCount
+	}
+	return defaultOptions.HTTPMaxRetryCount
+}
+
+func (opts *ClientOptions) restHost() string {
+	if opts.RestHost != "" {
+		return opts.RestHost
+	}
+	if opts.Environment != "" {
+		return opts.Environment + "-" + defaultOptions.RestHost
+	}
+	return defaultOptions.RestHost
+}
+
+func (opts *ClientOptions) realtimeHost() string {
+	if opts.RealtimeHost != "" {
+		return opts.RealtimeHost
+	}
+	if opts.Environment != "" {
+		return opts.Environment + "-" + defaultOptions.RealtimeHost
 	}
+	return defaultOptions.RealtimeHost
+}
+
+func (opts *ClientOptions) restURL() string {
+	return opts.restHostURL(opts.restHost())
+}
+
+func (opts *ClientOptions) restHostURL(host string) string {
 	if opts.NoTLS {
 		return "http://" + host
 	}
@@ -227,19 +310,49 @@ func (opts *ClientOptions) restURL() string {
 }
 
 func (opts *ClientOptions) realtimeURL() string {
-	host := opts.RealtimeHost
-	if host == "" {
-		host = defaultOptions.RealtimeHost
-		if opts.Environment != "" {
-			host = opts.Environment + "-" + host
-		}
-	}
+	return opts.realtimeHostURL(opts.realtimeHost())
+}
+
+func (opts *ClientOptions) realtimeHostURL(host string) string {
 	if opts.NoTLS {
 		return "ws://" + net.JoinHostPort(host, "80")
 	}
 	return "wss://" + net.JoinHostPort(host, "443")
 }
 
+func (opts *ClientOptions) restFallbackHosts() []string {
+	return opts.fallbackHosts(opts.RestHost != "")
+}
+
+func (opts *ClientOptions) realtimeFallbackHosts() []string {
+	return opts.fallbackHosts(opts.RealtimeHost != "")
+}
+
+// fallbackHosts gives a shuffled list of fallback hosts. The default ones
+// are not used when custom primary host was configured.
+func (opts *ClientOptions) fallbackHosts(custom bool) []string {
+	var hosts []string
+	switch {
+	case opts.FallbackHosts != nil:
+		hosts = append(hosts, opts.FallbackHosts...)
+	case custom:
+		return nil
+	case opts.Environment != "":
+		for _, host := range defaultFallbackHosts {
+			// a.ably-realtime.com -> env-a-fallback.ably-realtime.com
+			i := strings.IndexRune(host, '.')
+			hosts = append(hosts, opts.Environment+"-"+host[:i]+"-fallback"+host[i:])
+		}
+	default:
+		hosts = append(hosts, defaultFallbackHosts...)
+	}
+	for i := len(hosts) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		hosts[i], hosts[j] = hosts[j], hosts[i]
+	}
+	return hosts
+}
+
 func (opts *ClientOptions) httpclient() *http.Client {
 	if opts.HTTPClient != nil {
 		return opts.HTTPClient
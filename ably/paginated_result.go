@@ -36,14 +36,16 @@ type PaginatedResult struct {
 	typItems interface{}
 	typ      reflect.Type
 	query    QueryFunc
+	decode   func(items interface{}) // post-processes decoded items; may be nil
 	logger   *Logger
 }
 
 func newPaginatedResult(typ reflect.Type, path string, params *PaginateParams,
-	query QueryFunc, log *Logger) (*PaginatedResult, error) {
+	query QueryFunc, decode func(interface{}), log *Logger) (*PaginatedResult, error) {
 	p := &PaginatedResult{
 		typ:    typ,
 		query:  query,
+		decode: decode,
 		logger: log,
 	}
 	builtPath, err := p.buildPaginatedPath(path, params)
@@ -65,6 +67,9 @@ func newPaginatedResult(typ reflect.Type, path string, params *PaginateParams,
 		return nil, err
 	}
 	p.typItems = v.Elem().Interface()
+	if p.decode != nil {
+		p.decode(p.typItems)
+	}
 	return p, nil
 }
 
@@ -77,7 +82,7 @@ func (p *PaginatedResult) Next() (*PaginatedResult, error) {
 		return nil, newErrorf(ErrCodeNotFound, "no next page after %q", p.path)
 	}
 	nextPage := p.buildPath(p.path, nextPath)
-	return newPaginatedResult(p.typ, nextPage, nil, p.query, p.logger)
+	return newPaginatedResult(p.typ, nextPage, nil, p.query, p.decode, p.logger)
 }
 
 // Items gives a slice of results of the current page.
@@ -0,0 +1,135 @@
+package proto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Encoder transforms message payloads for a single encoding, e.g. "gzip".
+//
+// Encode is applied when the encoding is added to the right of the message
+// Encoding, Decode when it's removed from it. Data passed to them is
+// a string, []byte or, for the "json" encoding, any value.
+type Encoder interface {
+	Encode(data interface{}) (interface{}, error)
+	Decode(data interface{}) (interface{}, error)
+}
+
+// UnknownEncodingError is returned when decoding a message, which Encoding
+// contains an encoding with no Encoder registered for it.
+//
+// The error is non-fatal: Data is decoded up to the unknown encoding and
+// Encoding is left with the encodings, which could not be decoded.
+type UnknownEncodingError struct {
+	Encoding string
+}
+
+func (err *UnknownEncodingError) Error() string {
+	return fmt.Sprintf("unknown encoding %q", err.Encoding)
+}
+
+var encoders = struct {
+	sync.RWMutex
+	m map[string]Encoder
+}{m: make(map[string]Encoder)}
+
+func init() {
+	RegisterEncoder(Base64, base64Encoder{})
+	RegisterEncoder(UTF8, utf8Encoder{})
+	RegisterEncoder(JSON, jsonEncoder{})
+}
+
+// RegisterEncoder makes enc to be used for the encoding of the given name.
+// Registering an encoder under the name of an already registered one,
+// including the default "base64", "utf-8" and "json", replaces it.
+//
+// Cipher encodings are handled by the crypto package and can't be
+// registered here. If enc is nil or name is invalid, the function panics.
+func RegisterEncoder(name string, enc Encoder) {
+	if enc == nil {
+		panic("proto: RegisterEncoder using nil Encoder")
+	}
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, cipherPrefix) {
+		panic("proto: RegisterEncoder using invalid name " + name)
+	}
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[name] = enc
+}
+
+func lookupEncoder(name string) (Encoder, bool) {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	enc, ok := encoders.m[name]
+	return enc, ok
+}
+
+type base64Encoder struct{}
+
+func (base64Encoder) Encode(data interface{}) (interface{}, error) {
+	p, err := toBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(p), nil
+}
+
+func (base64Encoder) Decode(data interface{}) (interface{}, error) {
+	// Binary transports deliver the base64 text as string, JSON one
+	// too, but the text may be a result of other decoding step.
+	p, err := toBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(p)))
+	n, err := base64.StdEncoding.Decode(b, p)
+	if err != nil {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+// utf8Encoder only marks the data to be decoded into a string.
+type utf8Encoder struct{}
+
+func (utf8Encoder) Encode(data interface{}) (interface{}, error) {
+	return data, nil
+}
+
+func (utf8Encoder) Decode(data interface{}) (interface{}, error) {
+	p, err := toBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+// jsonEncoder marshals data into a JSON string, unless it's a string
+// already, in which case it's assumed to be a valid JSON.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(data interface{}) (interface{}, error) {
+	if s, ok := data.(string); ok {
+		return s, nil
+	}
+	p, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+func (jsonEncoder) Decode(data interface{}) (interface{}, error) {
+	p, err := toBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(p, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,243 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MessageExtras is a typed view of Message.Extras, which gives the known
+// sections of the extras as structs.
+//
+// Fields unknown to the library, at the top level as well as within
+// the known sections, are kept in Other of the enclosing struct and are
+// sent and received as is.
+type MessageExtras struct {
+	Push    *PushExtras            `json:"push,omitempty"`    // push notification payload
+	Headers map[string]interface{} `json:"headers,omitempty"` // user-provided message headers
+	Ref     *MessageRef            `json:"ref,omitempty"`     // reference to another message
+
+	// Other holds sections of the extras unknown to the library, keyed
+	// by their names.
+	Other map[string]interface{} `json:"-"`
+}
+
+// PushExtras is a push notification payload, delivered to devices subscribed
+// to the channel the message is published on.
+type PushExtras struct {
+	Notification *PushNotification      `json:"notification,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+
+	// Per-platform overrides, which are passed as is to APNs, FCM and
+	// Web Push respectively.
+	APNs map[string]interface{} `json:"apns,omitempty"`
+	FCM  map[string]interface{} `json:"fcm,omitempty"`
+	Web  map[string]interface{} `json:"web,omitempty"`
+
+	Other map[string]interface{} `json:"-"` // fields unknown to the library
+}
+
+// PushNotification describes a notification displayed to the user.
+type PushNotification struct {
+	Title       string `json:"title,omitempty"`
+	Body        string `json:"body,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Sound       string `json:"sound,omitempty"`
+	CollapseKey string `json:"collapseKey,omitempty"`
+
+	Other map[string]interface{} `json:"-"` // fields unknown to the library
+}
+
+// MessageRef references another message, e.g. the one the message replies
+// to in a thread.
+type MessageRef struct {
+	Type       string `json:"type,omitempty"`       // type of the reference, e.g. "com.ably.reply"
+	Timeserial string `json:"timeserial,omitempty"` // timeserial of the referenced message
+
+	Other map[string]interface{} `json:"-"` // fields unknown to the library
+}
+
+var (
+	extrasFields       = map[string]bool{"push": true, "headers": true, "ref": true}
+	pushFields         = map[string]bool{"notification": true, "data": true, "apns": true, "fcm": true, "web": true}
+	notificationFields = map[string]bool{"title": true, "body": true, "icon": true, "sound": true, "collapseKey": true}
+	refFields          = map[string]bool{"type": true, "timeserial": true}
+)
+
+func (e MessageExtras) MarshalJSON() ([]byte, error) {
+	type extras MessageExtras
+	return marshalWithOther(extras(e), e.Other, extrasFields)
+}
+
+func (e *MessageExtras) UnmarshalJSON(p []byte) error {
+	type extras MessageExtras
+	var v extras
+	other, err := unmarshalWithOther(p, &v, extrasFields)
+	if err != nil {
+		return err
+	}
+	*e = MessageExtras(v)
+	e.Other = other
+	return nil
+}
+
+func (p PushExtras) MarshalJSON() ([]byte, error) {
+	type push PushExtras
+	return marshalWithOther(push(p), p.Other, pushFields)
+}
+
+func (p *PushExtras) UnmarshalJSON(b []byte) error {
+	type push PushExtras
+	var v push
+	other, err := unmarshalWithOther(b, &v, pushFields)
+	if err != nil {
+		return err
+	}
+	*p = PushExtras(v)
+	p.Other = other
+	return nil
+}
+
+func (n PushNotification) MarshalJSON() ([]byte, error) {
+	type notification PushNotification
+	return marshalWithOther(notification(n), n.Other, notificationFields)
+}
+
+func (n *PushNotification) UnmarshalJSON(p []byte) error {
+	type notification PushNotification
+	var v notification
+	other, err := unmarshalWithOther(p, &v, notificationFields)
+	if err != nil {
+		return err
+	}
+	*n = PushNotification(v)
+	n.Other = other
+	return nil
+}
+
+func (r MessageRef) MarshalJSON() ([]byte, error) {
+	type ref MessageRef
+	return marshalWithOther(ref(r), r.Other, refFields)
+}
+
+func (r *MessageRef) UnmarshalJSON(p []byte) error {
+	type ref MessageRef
+	var v ref
+	other, err := unmarshalWithOther(p, &v, refFields)
+	if err != nil {
+		return err
+	}
+	*r = MessageRef(v)
+	r.Other = other
+	return nil
+}
+
+// marshalWithOther encodes v, which is a struct with the given known fields,
+// as a JSON object extended with the other fields. Other fields named
+// as known ones are ignored.
+func marshalWithOther(v interface{}, other map[string]interface{}, known map[string]bool) ([]byte, error) {
+	p, err := json.Marshal(v)
+	if err != nil || len(other) == 0 {
+		return p, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(p, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range other {
+		if !known[k] {
+			m[k] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+// unmarshalWithOther decodes the JSON object p into v, which is a struct with
+// the given known fields, and returns the remaining fields of the object.
+func unmarshalWithOther(p []byte, v interface{}, known map[string]bool) (map[string]interface{}, error) {
+	if err := json.Unmarshal(p, v); err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(p, &m); err != nil {
+		return nil, err
+	}
+	var other map[string]interface{}
+	for k, v := range m {
+		if known[k] {
+			continue
+		}
+		if other == nil {
+			other = make(map[string]interface{})
+		}
+		other[k] = v
+	}
+	return other, nil
+}
+
+// ParseExtras gives Extras as MessageExtras. The Extras are not modified.
+//
+// The values within the result are the ones encoding/json decodes into
+// an interface{}, e.g. numbers are float64.
+func (m *Message) ParseExtras() (*MessageExtras, error) {
+	e := &MessageExtras{}
+	if len(m.Extras) == 0 {
+		return e, nil
+	}
+	p, err := json.Marshal(normalize(m.Extras))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(p, e); err != nil {
+		return nil, fmt.Errorf("invalid message extras: %v", err)
+	}
+	return e, nil
+}
+
+// SetExtras replaces Extras with the given ones. If e is nil or empty,
+// Extras is set to nil, so it's not sent.
+func (m *Message) SetExtras(e *MessageExtras) error {
+	if e == nil {
+		m.Extras = nil
+		return nil
+	}
+	p, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var extras map[string]interface{}
+	if err := json.Unmarshal(p, &extras); err != nil {
+		return err
+	}
+	if len(extras) == 0 {
+		extras = nil
+	}
+	m.Extras = extras
+	return nil
+}
+
+// normalize converts maps decoded by msgpack, which have interface{} keys,
+// into ones with string keys, so the value can be encoded as JSON.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, v := range v {
+			m[fmt.Sprint(k)] = normalize(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, v := range v {
+			m[k] = normalize(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, v := range v {
+			s[i] = normalize(v)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -1,15 +1,11 @@
 package proto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
-	"errors"
 	"fmt"
-	"io"
-	"strconv"
 	"strings"
+
+	"github.com/ably/ably-go/ably/crypto"
 )
 
 // encodings
@@ -17,6 +13,8 @@ const (
 	UTF8   = "utf-8"
 	JSON   = "json"
 	Base64 = "base64"
+
+	cipherPrefix = "cipher+"
 )
 
 type Message struct {
@@ -24,10 +22,15 @@ type Message struct {
 	ClientID     string                 `json:"clientId,omitempty" msgpack:"clientId,omitempty"`
 	ConnectionID string                 `json:"connectionId,omitempty" msgpack:"connectionID,omitempty"`
 	Name         string                 `json:"name,omitempty" msgpack:"name,omitempty"`
-	Data         string                 `json:"data,omitempty" msgpack:"data,omitempty"`
+	Data         interface{}            `json:"data,omitempty" msgpack:"data,omitempty"`
 	Encoding     string                 `json:"encoding,omitempty" msgpack:"encoding,omitempty"`
 	Timestamp    int64                  `json:"timestamp" msgpack:"timestamp"`
-	Extras       map[string]interface{} `json:"extras" msgpack:"extras"`
+	Extras       map[string]interface{} `json:"extras,omitempty" msgpack:"extras,omitempty"`
+
+	// DecodeError is set on a received message, which Data failed to be
+	// fully decoded, e.g. decrypted. Data and Encoding then describe
+	// the data as far as it was decoded.
+	DecodeError error `json:"-" msgpack:"-"`
 }
 
 // MemberKey returns string that allows to uniquely identify connected clients.
@@ -38,143 +41,220 @@ func (msg *Message) MemberKey() string {
 // DecodeData reads the current Encoding field and decode Data following it.
 // The Encoding field contains slash (/) separated values and will be read from right to left
 // to decode data.
-// For example, if Encodind is currently set to "json/base64" it will first try to decode data
-// using base64 decoding and then json. In this example JSON is not a real type used in the Go
-// library so the string is left untouched.
+// For example, if Encoding is currently set to "json/base64" it will first decode data
+// using base64 decoding into []byte and then unmarshal the JSON into a value of type
+// []interface{}, map[string]interface{}, string, float64 or bool. Data encoded with
+// "utf-8" is decoded into a string.
 // To be able to decode aes encoded string, the keys parameter must be present. Otherwise, DecodeData
 // will return an error.
+//
+// Encodings other than cipher ones are decoded with the Encoder registered
+// for them with RegisterEncoder.
+//
+// Decoded encodings are removed from the Encoding field. If decoding fails,
+// the message is left untouched, unless an encoding is unknown: then Data is
+// decoded up to it and *UnknownEncodingError is returned.
 func (m *Message) DecodeData(key []byte) error {
-	// strings.Split on empty string returns []string{""}
-	if m.Encoding == "" || m.Data == "" {
+	return m.decodeData(func(encoding string) (crypto.Cipher, error) {
+		return crypto.NewCipher(encoding, key)
+	})
+}
+
+// DecodeDataWith is like DecodeData, but decrypts Data with c if it was
+// encrypted with it, that is when the encoding includes c.Encoding().
+// Otherwise the Cipher registered for the encoding is used, which is not
+// given any key.
+func (m *Message) DecodeDataWith(c crypto.Cipher) error {
+	return m.decodeData(func(encoding string) (crypto.Cipher, error) {
+		if encoding == c.Encoding() {
+			return c, nil
+		}
+		return crypto.NewCipher(encoding, nil)
+	})
+}
+
+func (m *Message) decodeData(newCipher func(encoding string) (crypto.Cipher, error)) error {
+	if m.Encoding == "" || m.Data == nil {
 		return nil
 	}
+	data := m.Data
 	encodings := strings.Split(m.Encoding, "/")
 	for i := len(encodings) - 1; i >= 0; i-- {
-		switch encodings[i] {
-		case Base64:
-			data, err := base64.StdEncoding.DecodeString(m.Data)
+		if strings.HasPrefix(encodings[i], cipherPrefix) {
+			p, err := toBytes(data)
 			if err != nil {
 				return err
 			}
-			m.Data = string(data)
-		case JSON, UTF8:
-		default:
-			if err := m.Decrypt(encodings[i], key); err != nil {
+			c, err := newCipher(encodings[i])
+			if err != nil {
+				return err
+			}
+			if data, err = c.Decrypt(p); err != nil {
 				return err
 			}
+			continue
+		}
+		enc, ok := lookupEncoder(encodings[i])
+		if !ok {
+			m.Data = data
+			m.Encoding = strings.Join(encodings[:i+1], "/")
+			return &UnknownEncodingError{Encoding: encodings[i]}
+		}
+		var err error
+		if data, err = enc.Decode(data); err != nil {
+			return err
 		}
 	}
+	m.Data = data
+	m.Encoding = ""
 	return nil
 }
 
 // EncodeData resets the current Encoding field to an empty string and starts
 // encoding data following the given `encoding` parameter.
 // `encoding` contains slash (/) separated values that EncodeData will read
-// from left to right to encode the current Data string.
+// from left to right to encode the current Data, using the Encoder registered
+// for each of them with RegisterEncoder.
+// The "json" encoding marshals Data into a JSON string, unless it's already
+// a string, in which case it's assumed to be a valid JSON.
 // To encode data using aes, the keys parameter must be present. Otherwise,
 // EncodeData will return an error.
 func (m *Message) EncodeData(encoding string, key, iv []byte) error {
 	m.Encoding = ""
 	for _, encoding := range strings.Split(encoding, "/") {
-		switch encoding {
-		case Base64:
-			m.Data = base64.StdEncoding.EncodeToString([]byte(m.Data))
-			m.mergeEncoding(encoding)
-			continue
-		case JSON, UTF8:
-			m.mergeEncoding(encoding)
-			continue
-		default:
+		if strings.HasPrefix(encoding, cipherPrefix) {
 			if err := m.Encrypt(encoding, key, iv); err != nil {
 				return err
 			}
 			continue
 		}
+		if err := m.encodeWith(encoding); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (m *Message) getKeyLen(cipherStr string) int64 {
-	cipherConf := strings.Split(cipherStr, "+")
-	if len(cipherConf) != 2 || cipherConf[0] != "cipher" {
-		return 0
-	}
-	cipherParts := strings.Split(cipherConf[1], "-")
-	switch {
-	case cipherParts[0] != "aes":
-		// TODO log unknown encryption algorithm
-		return 0
-	case cipherParts[2] != "cbc":
-		// TODO log unknown mode
-		return 0
-	}
-	keylen, err := strconv.ParseInt(cipherParts[1], 10, 0)
+// EncodePayload prepares Data to be sent to Ably, encoding it as follows:
+//
+//   - string is sent as is
+//   - []byte is sent as is over binary transport, otherwise it's base64-encoded
+//   - other values are JSON-encoded into a string
+//
+// The given encodings, e.g. "gzip", are then applied from left to right
+// using the Encoders registered for them with RegisterEncoder. The Encoders
+// are given Data as []byte; a string is converted and marked as "utf-8".
+//
+// If c is non-nil, Data is also encrypted with it. The Encoding field
+// describes the steps taken, so other SDKs can decode the data back into
+// a value of the same type.
+//
+// If Encoding is already non-empty, Data is assumed to be encoded already
+// up to that point.
+func (m *Message) EncodePayload(c crypto.Cipher, binary bool, encodings ...string) error {
+	switch m.Data.(type) {
+	case nil:
+		return nil
+	case string, []byte:
+	default:
+		if err := m.encodeWith(JSON); err != nil {
+			return err
+		}
+	}
+	if c != nil || len(encodings) != 0 {
+		if s, ok := m.Data.(string); ok {
+			m.Data = []byte(s)
+			m.mergeEncoding(UTF8)
+		}
+	}
+	for _, encoding := range encodings {
+		if err := m.encodeWith(encoding); err != nil {
+			return err
+		}
+		switch m.Data.(type) {
+		case string, []byte:
+		default:
+			return fmt.Errorf("encoding %q gave data of unsupported %T type", encoding, m.Data)
+		}
+	}
+	if c != nil {
+		if err := m.EncryptWith(c); err != nil {
+			return err
+		}
+	}
+	if p, ok := m.Data.([]byte); ok && !binary {
+		m.Data = base64.StdEncoding.EncodeToString(p)
+		m.mergeEncoding(Base64)
+	}
+	return nil
+}
+
+// encodeWith encodes Data with the Encoder registered for the encoding
+// and appends the encoding to Encoding.
+func (m *Message) encodeWith(encoding string) error {
+	enc, ok := lookupEncoder(encoding)
+	if !ok {
+		return fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	data, err := enc.Encode(m.Data)
 	if err != nil {
-		// TODO parsing error
-		return 0
+		return err
 	}
-	return keylen
+	m.Data = data
+	m.mergeEncoding(encoding)
+	return nil
 }
 
+// Decrypt decrypts Data with the given key, according to cipherStr, which
+// is the cipher part of the encoding, e.g. "cipher+aes-128-cbc". The decrypted
+// Data is of []byte type.
 func (m *Message) Decrypt(cipherStr string, key []byte) error {
-	block, err := aes.NewCipher(key)
+	c, err := crypto.NewCipher(cipherStr, key)
 	if err != nil {
 		return err
 	}
-	if len(m.Data)%aes.BlockSize != 0 {
-		return errors.New("ciphertext is not a multiple of the block size")
+	p, err := toBytes(m.Data)
+	if err != nil {
+		return err
 	}
-	iv := []byte(m.Data[:aes.BlockSize])
-	m.Data = m.Data[aes.BlockSize:]
-	out := make([]byte, len(m.Data))
-	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, []byte(m.Data))
-	out, err = pkcs7Unpad(out, aes.BlockSize)
+	data, err := c.Decrypt(p)
 	if err != nil {
 		return err
 	}
-	m.Data = string(out)
+	m.Data = data
 	return nil
 }
 
+// Encrypt encrypts Data with the given key, according to cipherStr, which
+// is the cipher part of the encoding, e.g. "cipher+aes-128-cbc". If iv is
+// non-empty, it's used instead of a random one by the default AES CBC
+// cipher.
 func (m *Message) Encrypt(cipherStr string, key, iv []byte) error {
-	block, err := aes.NewCipher([]byte(key))
+	c, err := crypto.NewCipher(cipherStr, key)
 	if err != nil {
 		return err
 	}
-	// Apply padding to the payload if it's not already padded. Event if
-	// len(m.Data)%aes.Block == 0 we have no guarantee it's already padded.
-	// Try to unpad it and pad on failure.
-	if _, err = pkcs7Unpad([]byte(m.Data), aes.BlockSize); err != nil {
-		data, err := pkcs7Pad([]byte(m.Data), aes.BlockSize)
-		if err != nil {
-			return err
-		}
-		m.Data = string(data)
-	}
-	out := make([]byte, aes.BlockSize+len(m.Data))
-	if len(iv) == 0 {
-		if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-			return err
-		}
+	if p, ok := c.(*crypto.CipherParams); ok && len(iv) != 0 {
+		p.IV = iv
 	}
-	copy(out[:aes.BlockSize], iv)
-	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], []byte(m.Data))
-	m.Data = string(out)
-	m.mergeEncoding(cipherStr)
-	return nil
+	return m.EncryptWith(c)
 }
 
-// addPadding expands the message Data string to a suitable CBC valid length.
-// CBC encryption requires specific block size to work.
-func (m *Message) addPadding() {
-	padlen := byte(aes.BlockSize - (len(m.Data) % aes.BlockSize))
-	data := make([]byte, len(m.Data)+int(padlen))
-	padding := data[len(m.Data)-1:]
-	copy(data, m.Data)
-	for i := range padding {
-		padding[i] = padlen
-	}
-	m.Data = string(data)
+// EncryptWith encrypts Data, which must be either string or []byte,
+// with c and appends c.Encoding() to Encoding. The encrypted Data is
+// of []byte type.
+func (m *Message) EncryptWith(c crypto.Cipher) error {
+	p, err := toBytes(m.Data)
+	if err != nil {
+		return err
+	}
+	data, err := c.Encrypt(p)
+	if err != nil {
+		return err
+	}
+	m.Data = data
+	m.mergeEncoding(c.Encoding())
+	return nil
 }
 
 func (m *Message) mergeEncoding(encoding string) {
@@ -185,41 +265,14 @@ func (m *Message) mergeEncoding(encoding string) {
 	}
 }
 
-// Appends padding.
-func pkcs7Pad(data []byte, blocklen int) ([]byte, error) {
-	if blocklen <= 0 {
-		return nil, fmt.Errorf("invalid blocklen %d", blocklen)
-	}
-	padlen := 1
-	for ((len(data) + padlen) % blocklen) != 0 {
-		padlen = padlen + 1
-	}
-	p := make([]byte, len(data)+padlen)
-	copy(p, data)
-	for i := len(data); i < len(p); i++ {
-		p[i] = byte(padlen)
-	}
-	return p, nil
-}
-
-// Returns slice of the original data without padding.
-func pkcs7Unpad(data []byte, blocklen int) ([]byte, error) {
-	if blocklen <= 0 {
-		return nil, fmt.Errorf("invalid blocklen %d", blocklen)
-	}
-	if len(data)%blocklen != 0 || len(data) == 0 {
-		return nil, fmt.Errorf("invalid data len %d", len(data))
-	}
-	padlen := int(data[len(data)-1])
-	if padlen > blocklen || padlen == 0 {
-		// no padding found.
+// toBytes gives data, which is either string or []byte, as []byte.
+func toBytes(data interface{}) ([]byte, error) {
+	switch data := data.(type) {
+	case []byte:
 		return data, nil
+	case string:
+		return []byte(data), nil
+	default:
+		return nil, fmt.Errorf("unable to encode or decode data of %T type", data)
 	}
-	// check padding
-	for _, p := range data[len(data)-padlen:] {
-		if p != byte(padlen) {
-			return nil, fmt.Errorf("invalid padding character")
-		}
-	}
-	return data[:len(data)-padlen], nil
 }
@@ -1,11 +1,18 @@
 package proto_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
 
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/crypto"
 	"github.com/ably/ably-go/ably/proto"
 
+	"github.com/ably/ably-go/Godeps/_workspace/src/gopkg.in/vmihailenco/msgpack.v2"
+
 	. "github.com/ably/ably-go/Godeps/_workspace/src/github.com/onsi/ginkgo"
 	. "github.com/ably/ably-go/Godeps/_workspace/src/github.com/onsi/gomega"
 )
@@ -33,13 +40,13 @@ var _ = Describe("Message", func() {
 			It("returns the same string", func() {
 				err := message.DecodeData(aes128Key)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(message.Data).To(Equal(`{ "string": "utf-8™" }`))
+				Expect(message.Data).To(Equal(map[string]interface{}{"string": "utf-8™"}))
 			})
 
 			It("can decode data without the aes config", func() {
 				err := message.DecodeData(nil)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(message.Data).To(Equal(`{ "string": "utf-8™" }`))
+				Expect(message.Data).To(Equal(map[string]interface{}{"string": "utf-8™"}))
 			})
 
 			It("leaves message intact with empty payload", func() {
@@ -58,13 +65,13 @@ var _ = Describe("Message", func() {
 			It("decodes it into a byte array", func() {
 				err := message.DecodeData(aes128Key)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(message.Data).To(Equal("utf-8™\n"))
+				Expect(message.Data).To(Equal([]byte("utf-8™\n")))
 			})
 
 			It("can decode data without the aes config", func() {
 				err := message.DecodeData(nil)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(message.Data).To(Equal("utf-8™\n"))
+				Expect(message.Data).To(Equal([]byte("utf-8™\n")))
 			})
 
 			It("leaves message intact with empty payload", func() {
@@ -78,12 +85,12 @@ var _ = Describe("Message", func() {
 		Context("with json/utf-8/cipher+aes-128-cbc/base64", func() {
 			var (
 				encodedData string
-				decodedData string
+				decodedData []interface{}
 			)
 
 			BeforeEach(func() {
 				encodedData = "HO4cYSP8LybPYBPZPHQOtvmStzmExkdjvrn51J6cmaTZrGl+EsJ61sgxmZ6j6jcA"
-				decodedData = "[\"example\",\"json\",\"array\"]"
+				decodedData = []interface{}{"example", "json", "array"}
 				message = &proto.Message{
 					Data:     encodedData,
 					Encoding: "json/utf-8/cipher+aes-128-cbc/base64",
@@ -193,6 +200,210 @@ var _ = Describe("Message", func() {
 		})
 	})
 
+	Describe("EncodePayload", func() {
+		roundTrip := func(msg *proto.Message, binary bool) *proto.Message {
+			var out proto.Message
+			if binary {
+				p, err := msgpack.Marshal(msg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(msgpack.Unmarshal(p, &out)).To(Succeed())
+			} else {
+				p, err := json.Marshal(msg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(p, &out)).To(Succeed())
+			}
+			Expect(out.DecodeData(aes128Key)).To(Succeed())
+			return &out
+		}
+
+		for _, binary := range []bool{false, true} {
+			binary := binary
+			transport := map[bool]string{false: "JSON", true: "binary"}[binary]
+
+			Context("over "+transport+" transport", func() {
+				It("sends string as is", func() {
+					message = &proto.Message{Data: "utf-8™"}
+					Expect(message.EncodePayload(nil, binary)).To(Succeed())
+					Expect(message.Encoding).To(Equal(""))
+					Expect(roundTrip(message, binary).Data).To(Equal("utf-8™"))
+				})
+
+				It("sends []byte as binary", func() {
+					message = &proto.Message{Data: []byte{0, 1, 255}}
+					Expect(message.EncodePayload(nil, binary)).To(Succeed())
+					if binary {
+						Expect(message.Encoding).To(Equal(""))
+					} else {
+						Expect(message.Encoding).To(Equal("base64"))
+						Expect(message.Data).To(Equal("AAH/"))
+					}
+					Expect(roundTrip(message, binary).Data).To(Equal([]byte{0, 1, 255}))
+				})
+
+				It("sends other values as JSON", func() {
+					message = &proto.Message{Data: map[string]interface{}{"key": []interface{}{"value", 1.5}}}
+					Expect(message.EncodePayload(nil, binary)).To(Succeed())
+					Expect(message.Encoding).To(Equal("json"))
+					Expect(message.Data).To(Equal(`{"key":["value",1.5]}`))
+					Expect(roundTrip(message, binary).Data).To(Equal(map[string]interface{}{"key": []interface{}{"value", 1.5}}))
+				})
+
+				It("encrypts data", func() {
+					params, err := crypto.DefaultCipherParams(aes128Key)
+					Expect(err).NotTo(HaveOccurred())
+					message = &proto.Message{Data: []interface{}{"example"}}
+					Expect(message.EncodePayload(params, binary)).To(Succeed())
+					if binary {
+						Expect(message.Encoding).To(Equal("json/utf-8/cipher+aes-128-cbc"))
+					} else {
+						Expect(message.Encoding).To(Equal("json/utf-8/cipher+aes-128-cbc/base64"))
+					}
+					Expect(roundTrip(message, binary).Data).To(Equal([]interface{}{"example"}))
+				})
+			})
+		}
+	})
+
+	Describe("Extras", func() {
+		extras := &proto.MessageExtras{
+			Push: &proto.PushExtras{
+				Notification: &proto.PushNotification{
+					Title: "Hello",
+					Body:  "World",
+					Other: map[string]interface{}{"image": "https://example.com/image.png"},
+				},
+				Data:  map[string]interface{}{"key": "value", "count": float64(2)},
+				APNs:  map[string]interface{}{"apns-headers": map[string]interface{}{"apns-priority": "10"}},
+				Other: map[string]interface{}{"expiry": float64(3600)},
+			},
+			Headers: map[string]interface{}{"header": "value", "number": float64(1), "flag": true},
+			Ref: &proto.MessageRef{
+				Type:       "com.ably.reply",
+				Timeserial: "abc@1",
+				Other:      map[string]interface{}{"channel": "thread"},
+			},
+			Other: map[string]interface{}{"custom": "value"},
+		}
+
+		for _, binary := range []bool{false, true} {
+			binary := binary
+			transport := map[bool]string{false: "JSON", true: "binary"}[binary]
+
+			It("round trips over "+transport+" transport", func() {
+				message = &proto.Message{Name: "name"}
+				Expect(message.SetExtras(extras)).To(Succeed())
+				var out proto.Message
+				if binary {
+					p, err := msgpack.Marshal(message)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(msgpack.Unmarshal(p, &out)).To(Succeed())
+				} else {
+					p, err := json.Marshal(message)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(json.Unmarshal(p, &out)).To(Succeed())
+				}
+				got, err := out.ParseExtras()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got).To(Equal(extras))
+			})
+		}
+
+		It("keeps unknown fields of received extras", func() {
+			raw := map[string]interface{}{
+				"push": map[interface{}]interface{}{
+					"notification": map[interface{}]interface{}{"title": "Hello", "image": "https://example.com/image.png"},
+					"data":         map[interface{}]interface{}{"count": int64(2)},
+					"expiry":       int64(3600),
+				},
+				"headers": map[interface{}]interface{}{"number": int64(1), "flag": true},
+				"ref":     map[interface{}]interface{}{"type": "com.ably.reply", "channel": "thread"},
+				"custom":  []interface{}{"value"},
+			}
+			message = &proto.Message{Extras: raw}
+			got, err := message.ParseExtras()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Push.Notification.Title).To(Equal("Hello"))
+			Expect(got.Push.Notification.Other).To(Equal(map[string]interface{}{"image": "https://example.com/image.png"}))
+			Expect(got.Push.Other).To(Equal(map[string]interface{}{"expiry": float64(3600)}))
+			Expect(got.Headers).To(Equal(map[string]interface{}{"number": float64(1), "flag": true}))
+			Expect(got.Ref.Other).To(Equal(map[string]interface{}{"channel": "thread"}))
+			out := &proto.Message{}
+			Expect(out.SetExtras(got)).To(Succeed())
+			p, err := json.Marshal(out.Extras)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p).To(MatchJSON(`{
+				"push": {
+					"notification": {"title": "Hello", "image": "https://example.com/image.png"},
+					"data": {"count": 2},
+					"expiry": 3600
+				},
+				"headers": {"number": 1, "flag": true},
+				"ref": {"type": "com.ably.reply", "channel": "thread"},
+				"custom": ["value"]
+			}`))
+		})
+
+		It("omits empty extras", func() {
+			message = &proto.Message{Name: "name"}
+			Expect(message.SetExtras(&proto.MessageExtras{})).To(Succeed())
+			Expect(message.Extras).To(BeNil())
+			p, err := json.Marshal(message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(p)).NotTo(ContainSubstring("extras"))
+		})
+
+		It("fails to parse malformed extras", func() {
+			message = &proto.Message{Extras: map[string]interface{}{"headers": "not an object"}}
+			_, err := message.ParseExtras()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("RegisterEncoder", func() {
+		BeforeEach(func() {
+			proto.RegisterEncoder("test-gzip", gzipEncoder{})
+		})
+
+		It("applies custom encoders left-to-right on encode and right-to-left on decode", func() {
+			message = &proto.Message{Data: "compressed™"}
+			Expect(message.EncodePayload(nil, false, "test-gzip")).To(Succeed())
+			Expect(message.Encoding).To(Equal("utf-8/test-gzip/base64"))
+			Expect(message.DecodeData(nil)).To(Succeed())
+			Expect(message.Encoding).To(Equal(""))
+			Expect(message.Data).To(Equal("compressed™"))
+		})
+
+		It("encodes with custom encoders before encrypting", func() {
+			params, err := crypto.DefaultCipherParams(aes128Key)
+			Expect(err).NotTo(HaveOccurred())
+			message = &proto.Message{Data: map[string]interface{}{"key": "value"}}
+			Expect(message.EncodePayload(params, true, "test-gzip")).To(Succeed())
+			Expect(message.Encoding).To(Equal("json/utf-8/test-gzip/cipher+aes-128-cbc"))
+			Expect(message.DecodeData(aes128Key)).To(Succeed())
+			Expect(message.Data).To(Equal(map[string]interface{}{"key": "value"}))
+		})
+
+		It("fails to encode with an unknown encoding", func() {
+			message = &proto.Message{Data: "data"}
+			Expect(message.EncodePayload(nil, false, "unknown")).NotTo(Succeed())
+			Expect(message.EncodeData("json/unknown", nil, nil)).NotTo(Succeed())
+		})
+
+		It("decodes data up to an unknown encoding", func() {
+			message = &proto.Message{Data: "InVua25vd24gZGF0YSI=", Encoding: "utf-8/unknown/json/base64"}
+			err := message.DecodeData(nil)
+			Expect(err).To(Equal(&proto.UnknownEncodingError{Encoding: "unknown"}))
+			Expect(message.Encoding).To(Equal("utf-8/unknown"))
+			Expect(message.Data).To(Equal("unknown data"))
+		})
+
+		It("panics on invalid name", func() {
+			Expect(func() { proto.RegisterEncoder("a/b", gzipEncoder{}) }).To(Panic())
+			Expect(func() { proto.RegisterEncoder("cipher+gzip", gzipEncoder{}) }).To(Panic())
+			Expect(func() { proto.RegisterEncoder("gzip", nil) }).To(Panic())
+		})
+	})
+
 	Describe("CryptoDataFixtures", func() {
 		EncodeDecodeFixture := func(fixture string) func() {
 			return func() {
@@ -244,3 +455,25 @@ var _ = Describe("Message", func() {
 		Context("with a 256 keylength", EncodeDecodeFixture("test-resources/crypto-data-256.json"))
 	})
 })
+
+type gzipEncoder struct{}
+
+func (gzipEncoder) Encode(data interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data.([]byte)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipEncoder) Decode(data interface{}) (interface{}, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data.([]byte)))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -20,6 +20,11 @@ type ConnectionDetails struct {
 	MaxFrameSize       int64  `json:"maxFrameSize,omitempty" msgpack:"maxFrameSize,omitempty"`
 	MaxInboundRate     int64  `json:"maxInboundRate,omitempty" msgpack:"maxInboundRate,omitempty"`
 	ConnectionStateTTL int64  `json:"connectionStateTtl,omitempty" msgpack:"connectionStateTtl,omitempty"`
+	MaxIdleInterval    int64  `json:"maxIdleInterval,omitempty" msgpack:"maxIdleInterval,omitempty"`
+}
+
+type AuthDetails struct {
+	AccessToken string `json:"accessToken,omitempty" msgpack:"accessToken,omitempty"`
 }
 
 type ProtocolMessage struct {
@@ -32,6 +37,7 @@ type ProtocolMessage struct {
 	Channel           string             `json:"channel,omitempty" msgpack:"channel,omitempty"`
 	ChannelSerial     string             `json:"channelSerial,omitempty" msgpack:"channelSerial,omitempty"`
 	ConnectionDetails *ConnectionDetails `json:"connectionDetails,omitempty" msgpack:"connectionDetails,omitempty"`
+	Auth              *AuthDetails       `json:"auth,omitempty" msgpack:"auth,omitempty"`
 	Error             *Error             `json:"error,omitempty" msgpack:"error,omitempty"`
 	MsgSerial         int64              `json:"msgSerial,omitempty" msgpack:"msgSerial,omitempty"`
 	ConnectionSerial  int64              `json:"connectionSerial,omitempty" msgpack:"connectionSerial,omitempty"`
@@ -44,7 +50,7 @@ type ProtocolMessage struct {
 func (msg *ProtocolMessage) String() string {
 	switch msg.Action {
 	case ActionHeartbeat:
-		return fmt.Sprintf("(action=%q)", msg.Action)
+		return fmt.Sprintf("(action=%q, id=%q)", msg.Action, msg.ID)
 	case ActionAck, ActionNack:
 		return fmt.Sprintf("(action=%q, serial=%d, count=%d)", msg.Action, msg.MsgSerial, msg.Count)
 	case ActionConnect:
@@ -55,6 +61,8 @@ func (msg *ProtocolMessage) String() string {
 		return fmt.Sprintf("(action=%q)", msg.Action)
 	case ActionDisconnected:
 		return fmt.Sprintf("(action=%q)", msg.Action)
+	case ActionAuth:
+		return fmt.Sprintf("(action=%q)", msg.Action)
 	case ActionClose:
 		return fmt.Sprintf("(action=%q)", msg.Action)
 	case ActionClosed:
@@ -13,6 +13,15 @@ var (
 	errDetach = errors.New("attempted to detach channel from inactive connection")
 )
 
+// channelOps are operations, any of which allows for attaching to a channel.
+var channelOps = []Operation{
+	OpSubscribe,
+	OpPublish,
+	OpPresence,
+	OpHistory,
+	OpChannelMetadata,
+}
+
 type chanSlice []*RealtimeChannel
 
 func (ch chanSlice) Len() int           { return len(ch) }
@@ -36,18 +45,22 @@ func newChannels(client *RealtimeClient) *Channels {
 }
 
 // Get looks up a channel given by the name and creates it if it does not exist
-// already.
+// already. If opts are given, the last of them replaces the options
+// of the channel.
 //
 // It is safe to call Get from multiple goroutines - a single channel is
 // guaranteed to be created only once for multiple calls to Get from different
 // goroutines.
-func (ch *Channels) Get(name string) *RealtimeChannel {
+func (ch *Channels) Get(name string, opts ...*ChannelOptions) *RealtimeChannel {
 	ch.mtx.Lock()
 	c, ok := ch.chans[name]
 	if !ok {
 		c = newRealtimeChannel(name, ch.client)
 		ch.chans[name] = c
 	}
+	if len(opts) != 0 {
+		c.options = opts[len(opts)-1]
+	}
 	ch.mtx.Unlock()
 	return c
 }
@@ -94,6 +107,9 @@ type RealtimeChannel struct {
 	subs   *subscriptions
 	queue  *msgQueue
 	listen chan State
+	connID string // ID of the connection the channel was attached over
+
+	options *ChannelOptions // guarded by client.Channels.mtx
 }
 
 func newRealtimeChannel(name string, client *RealtimeClient) *RealtimeChannel {
@@ -109,7 +125,7 @@ func newRealtimeChannel(name string, client *RealtimeClient) *RealtimeChannel {
 	if c.opts().Listener != nil {
 		c.On(c.opts().Listener)
 	}
-	c.client.Connection.On(c.listen, StateConnFailed, StateConnClosed)
+	c.client.Connection.On(c.listen, StateConnConnected, StateConnFailed, StateConnClosed)
 	go c.listenLoop()
 	return c
 }
@@ -120,6 +136,13 @@ func (c *RealtimeChannel) listenLoop() {
 		active := c.isActive()
 		c.state.Unlock()
 		switch state.State {
+		case StateConnConnected:
+			if state.Update {
+				// Connection was reauthorised in-band, the channel
+				// stays attached.
+				break
+			}
+			c.reattach()
 		case StateConnFailed:
 			if active {
 				c.state.syncSet(StateChanFailed, state.Err)
@@ -159,6 +182,9 @@ func (c *RealtimeChannel) attach(result bool) (Result, error) {
 	if !c.client.Connection.lockIsActive() {
 		return nil, c.state.set(StateChanFailed, errAttach)
 	}
+	if err := c.client.Auth.checkCapability(c.Name, channelOps...); err != nil {
+		return nil, err
+	}
 	c.state.set(StateChanAttaching, nil)
 	var res Result
 	if result {
@@ -175,6 +201,24 @@ func (c *RealtimeChannel) attach(result bool) (Result, error) {
 	return res, nil
 }
 
+// reattach sends ATTACH message again for attached channel if the connection
+// was not resumed after it got reconnected, which is when its ID changes.
+func (c *RealtimeChannel) reattach() {
+	c.state.Lock()
+	defer c.state.Unlock()
+	if c.state.current != StateChanAttached || c.connID == c.client.Connection.ID() {
+		return
+	}
+	c.state.set(StateChanAttaching, nil)
+	msg := &proto.ProtocolMessage{
+		Action:  proto.ActionAttach,
+		Channel: c.state.channel,
+	}
+	if err := c.client.Connection.send(msg, nil); err != nil {
+		c.state.set(StateChanFailed, err)
+	}
+}
+
 // Detach initiates detach request, which is being processed on a separate
 // goroutine.
 //
@@ -281,18 +325,36 @@ func (c *RealtimeChannel) Off(ch chan<- State, states ...StateEnum) {
 }
 
 // Publish publishes a message on the channel, which is send on separate
-// goroutine. Publish does not block.
+// goroutine. Publish does not block. The data is either a string, []byte
+// or a value that can be encoded as JSON.
 //
 // This implicitly attaches the channel if it's not already attached.
-func (c *RealtimeChannel) Publish(name string, data string) (Result, error) {
+func (c *RealtimeChannel) Publish(name string, data interface{}) (Result, error) {
 	return c.PublishAll([]*proto.Message{{Name: name, Data: data}})
 }
 
+// PublishWithExtras is like Publish, but sends the message with the given
+// extras, e.g. a push notification payload or headers.
+func (c *RealtimeChannel) PublishWithExtras(name string, data interface{}, extras *proto.MessageExtras) (Result, error) {
+	msg, err := newMessage(name, data, extras)
+	if err != nil {
+		return nil, err
+	}
+	return c.PublishAll([]*proto.Message{msg})
+}
+
 // PublishAll publishes all given messages on the channel at once.
 // PublishAll does not block.
 //
 // This implicitly attaches the channel if it's not already attached.
 func (c *RealtimeChannel) PublishAll(messages []*proto.Message) (Result, error) {
+	if err := c.client.Auth.checkCapability(c.Name, OpPublish); err != nil {
+		return nil, err
+	}
+	messages, err := c.channelOpts().encodeAll(messages, c.opts().protocol() == protocolMsgPack)
+	if err != nil {
+		return nil, err
+	}
 	msg := &proto.ProtocolMessage{
 		Action:   proto.ActionMessage,
 		Channel:  c.state.channel,
@@ -303,9 +365,9 @@ func (c *RealtimeChannel) PublishAll(messages []*proto.Message) (Result, error)
 
 // History gives the channel's message history according to the given parameters.
 // The returned result can be inspected for the messages via the Messages()
-// method.
+// method. The messages are decoded with the options of the realtime channel.
 func (c *RealtimeChannel) History(params *PaginateParams) (*PaginatedResult, error) {
-	return c.client.rest.Channel(c.Name).History(params)
+	return c.client.rest.Channel(c.Name).history(params, c.channelOpts())
 }
 
 func (c *RealtimeChannel) send(msg *proto.ProtocolMessage) (Result, error) {
@@ -345,18 +407,24 @@ func (c *RealtimeChannel) notify(msg *proto.ProtocolMessage) {
 	switch msg.Action {
 	case proto.ActionAttached:
 		c.Presence.onAttach(msg)
-		c.state.syncSet(StateChanAttached, nil)
+		c.state.Lock()
+		c.connID = c.client.Connection.ID()
+		c.state.set(StateChanAttached, nil)
+		c.state.Unlock()
 		c.queue.Flush()
 	case proto.ActionDetached:
 		c.state.syncSet(StateChanDetached, nil)
 	case proto.ActionSync:
+		c.channelOpts().decodeAll(msg.Presence, c.logger())
 		c.Presence.processIncomingMessage(msg, syncSerial(msg))
 	case proto.ActionPresence:
+		c.channelOpts().decodeAll(msg.Presence, c.logger())
 		c.Presence.processIncomingMessage(msg, "")
 	case proto.ActionError:
 		c.state.syncSet(StateChanFailed, newErrorProto(msg.Error))
 		c.queue.Fail(newErrorProto(msg.Error))
 	case proto.ActionMessage:
+		c.channelOpts().decodeAll(msg.Messages, c.logger())
 		c.subs.messageEnqueue(msg)
 	default:
 	}
@@ -366,6 +434,12 @@ func (c *RealtimeChannel) isActive() bool {
 	return c.state.current == StateChanAttaching || c.state.current == StateChanAttached
 }
 
+func (c *RealtimeChannel) channelOpts() *ChannelOptions {
+	c.client.Channels.mtx.Lock()
+	defer c.client.Channels.mtx.Unlock()
+	return c.options
+}
+
 func (c *RealtimeChannel) opts() *ClientOptions {
 	return c.client.opts()
 }
@@ -1,13 +1,19 @@
 package ably_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/ably/ably-go/ably"
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/crypto"
 	"github.com/ably/ably-go/ably/proto"
 )
 
@@ -198,3 +204,279 @@ func TestRealtimeChannel_Close(t *testing.T) {
 	}
 	t.Error(err)
 }
+
+func TestRealtimeChannel_Capability(t *testing.T) {
+	client, conn := connectedFakeClient(t, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				return &ably.TokenDetails{
+					Token:         "token",
+					ClientID:      "client",
+					RawCapability: `{"feed":["subscribe"]}`,
+				}, nil
+			},
+		},
+	})
+	const forbidden = ably.ErrCodeOperationNotPermittedWithProvidedCapability
+	feed := client.Channels.Get("feed")
+	if _, err := feed.Publish("name", "data"); ably.ErrorCode(err) != forbidden {
+		t.Errorf("Publish(): want code=%d; got %d (%v)", forbidden, ably.ErrorCode(err), err)
+	}
+	if _, err := feed.Presence.Enter("data"); ably.ErrorCode(err) != forbidden {
+		t.Errorf("Presence.Enter(): want code=%d; got %d (%v)", forbidden, ably.ErrorCode(err), err)
+	}
+	if _, err := client.Channels.Get("other").Attach(); ably.ErrorCode(err) != forbidden {
+		t.Errorf("Attach(): want code=%d; got %d (%v)", forbidden, ably.ErrorCode(err), err)
+	}
+	if _, err := feed.Attach(); err != nil {
+		t.Errorf("Attach()=%v", err)
+	}
+	if msg, err := conn.Sent(); err != nil || msg.Action != proto.ActionAttach || msg.Channel != "feed" {
+		t.Errorf("want ATTACH for %q to be sent; got %v (%v)", "feed", msg, err)
+	}
+}
+
+func TestRealtimeChannel_Cipher(t *testing.T) {
+	params, err := crypto.DefaultCipherParams([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("DefaultCipherParams()=%v", err)
+	}
+	feed, conn := attachedFakeChannel(t, &ably.ChannelOptions{Cipher: params})
+	sub, err := feed.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe()=%v", err)
+	}
+	defer sub.Close()
+	if _, err := feed.Publish("name", "secret data"); err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+	sent, err := conn.Sent()
+	if err != nil || sent.Action != proto.ActionMessage || len(sent.Messages) != 1 {
+		t.Fatalf("want MESSAGE to be sent; got %v (%v)", sent, err)
+	}
+	// Binary transport is used, so the ciphertext is not base64-encoded.
+	const encoding = "utf-8/cipher+aes-128-cbc"
+	if m := sent.Messages[0]; m.Encoding != encoding || bytes.Contains(m.Data.([]byte), []byte("secret")) {
+		t.Fatalf("want message to be encrypted; got encoding=%q data=%q", m.Encoding, m.Data)
+	}
+	malformed := proto.Message{Name: "name", Data: "bm90IGVuY3J5cHRlZA==", Encoding: encoding + "/base64"}
+	in := malformed // received message is decoded in place
+	conn.in <- &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "feed",
+		Messages: []*proto.Message{sent.Messages[0], &in},
+	}
+	for _, want := range []proto.Message{
+		{Name: "name", Data: "secret data"},
+		malformed,
+	} {
+		select {
+		case m := <-sub.MessageChannel():
+			if m.Data != want.Data || m.Encoding != want.Encoding {
+				t.Errorf("want data=%q encoding=%q; got data=%q encoding=%q", want.Data, want.Encoding, m.Data, m.Encoding)
+			}
+			if (m.DecodeError != nil) != (want.Encoding != "") {
+				t.Errorf("want DecodeError only for undecoded message; got %v (encoding=%q)", m.DecodeError, m.Encoding)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiting for message timed out")
+		}
+	}
+}
+
+// xorCipher is a cipher, which doesn't need a key, so it's usable on
+// channels with no Cipher once it's registered.
+type xorCipher struct{}
+
+func (xorCipher) Encoding() string { return "cipher+test-xor" }
+
+func (xorCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	p := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		p[i] = b ^ 0x55
+	}
+	return p, nil
+}
+
+func (c xorCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	return c.Encrypt(ciphertext)
+}
+
+func TestRealtimeChannel_RegisteredCipher(t *testing.T) {
+	crypto.RegisterCipher("test-xor", func(string, []byte) (crypto.Cipher, error) {
+		return xorCipher{}, nil
+	})
+	feed, conn := attachedFakeChannel(t, nil)
+	sub, err := feed.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe()=%v", err)
+	}
+	defer sub.Close()
+	data, _ := xorCipher{}.Encrypt([]byte("secret data"))
+	unregistered := proto.Message{Name: "name", Data: data, Encoding: "utf-8/cipher+test-unregistered"}
+	in := unregistered // received message is decoded in place
+	conn.in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: "feed",
+		Messages: []*proto.Message{
+			{Name: "name", Data: data, Encoding: "utf-8/cipher+test-xor"},
+			&in,
+		},
+	}
+	for _, want := range []proto.Message{
+		{Name: "name", Data: "secret data"},
+		unregistered,
+	} {
+		select {
+		case m := <-sub.MessageChannel():
+			if !reflect.DeepEqual(m.Data, want.Data) || m.Encoding != want.Encoding {
+				t.Errorf("want data=%q encoding=%q; got data=%q encoding=%q", want.Data, want.Encoding, m.Data, m.Encoding)
+			}
+			if (m.DecodeError != nil) != (want.Encoding != "") {
+				t.Errorf("want DecodeError only for undecoded message; got %v (encoding=%q)", m.DecodeError, m.Encoding)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiting for message timed out")
+		}
+	}
+}
+
+type reverseEncoder struct{}
+
+func (reverseEncoder) Encode(data interface{}) (interface{}, error) {
+	p := append([]byte(nil), data.([]byte)...)
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	return p, nil
+}
+
+func (e reverseEncoder) Decode(data interface{}) (interface{}, error) {
+	return e.Encode(data)
+}
+
+func TestRealtimeChannel_Encodings(t *testing.T) {
+	proto.RegisterEncoder("test-reverse", reverseEncoder{})
+	feed, conn := attachedFakeChannel(t, &ably.ChannelOptions{Encodings: []string{"test-reverse"}})
+	sub, err := feed.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe()=%v", err)
+	}
+	defer sub.Close()
+	if _, err := feed.Publish("name", "data"); err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+	sent, err := conn.Sent()
+	if err != nil || sent.Action != proto.ActionMessage || len(sent.Messages) != 1 {
+		t.Fatalf("want MESSAGE to be sent; got %v (%v)", sent, err)
+	}
+	const encoding = "utf-8/test-reverse"
+	if m := sent.Messages[0]; m.Encoding != encoding || !bytes.Equal(m.Data.([]byte), []byte("atad")) {
+		t.Fatalf("want encoding=%q data=%q; got encoding=%q data=%q", encoding, "atad", m.Encoding, m.Data)
+	}
+	unknown := &proto.Message{Name: "name", Data: "YXRhZA==", Encoding: "utf-8/unknown/test-reverse/base64"}
+	conn.in <- &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "feed",
+		Messages: []*proto.Message{sent.Messages[0], unknown},
+	}
+	for _, want := range []proto.Message{
+		{Name: "name", Data: "data"},
+		{Name: "name", Data: []byte("data"), Encoding: "utf-8/unknown"},
+	} {
+		select {
+		case m := <-sub.MessageChannel():
+			if !reflect.DeepEqual(m.Data, want.Data) || m.Encoding != want.Encoding {
+				t.Errorf("want data=%q encoding=%q; got data=%q encoding=%q", want.Data, want.Encoding, m.Data, m.Encoding)
+			}
+			if _, ok := m.DecodeError.(*proto.UnknownEncodingError); ok != (want.Encoding != "") {
+				t.Errorf("want UnknownEncodingError only for undecoded message; got %v (encoding=%q)", m.DecodeError, m.Encoding)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiting for message timed out")
+		}
+	}
+}
+
+func TestRealtimeChannel_PublishWithExtras(t *testing.T) {
+	feed, conn := attachedFakeChannel(t, nil)
+	sub, err := feed.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe()=%v", err)
+	}
+	defer sub.Close()
+	extras := &proto.MessageExtras{
+		Push:    &proto.PushExtras{Notification: &proto.PushNotification{Title: "title"}},
+		Headers: map[string]interface{}{"key": "value"},
+		Ref:     &proto.MessageRef{Type: "com.ably.reply", Timeserial: "abc@1"},
+		Other:   map[string]interface{}{"custom": "value"},
+	}
+	if _, err := feed.PublishWithExtras("name", "data", extras); err != nil {
+		t.Fatalf("PublishWithExtras()=%v", err)
+	}
+	sent, err := conn.Sent()
+	if err != nil || sent.Action != proto.ActionMessage || len(sent.Messages) != 1 {
+		t.Fatalf("want MESSAGE to be sent; got %v (%v)", sent, err)
+	}
+	if got := sent.Messages[0].Extras; got["custom"] != "value" || got["headers"] == nil {
+		t.Fatalf("want extras to be sent; got %v", got)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "feed",
+		Messages: sent.Messages,
+	}
+	select {
+	case m := <-sub.MessageChannel():
+		got, err := m.ParseExtras()
+		if err != nil {
+			t.Fatalf("ParseExtras()=%v", err)
+		}
+		if !reflect.DeepEqual(got, extras) {
+			t.Errorf("want extras=%+v; got %+v", extras, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting for message timed out")
+	}
+}
+
+func TestRealtimeChannel_History(t *testing.T) {
+	proto.RegisterEncoder("test-reverse", reverseEncoder{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"name":"name","data":"YXRhZA==","encoding":"utf-8/test-reverse/base64"},`+
+			`{"name":"name","data":"YXRhZA==","encoding":"utf-8/unknown/base64"}]`)
+	}))
+	defer srv.Close()
+	opts := fakeOptions(newFakeDialer(), &ably.ClientOptions{
+		NoTLS:            true,
+		NoBinaryProtocol: true,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy: func(*http.Request) (*url.URL, error) { return url.Parse(srv.URL) },
+			},
+		},
+	})
+	opts.Key = ""
+	opts.Token = "fake-token"
+	client, err := ably.NewRealtimeClient(opts)
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	defer client.Close()
+	feed := client.Channels.Get("feed", &ably.ChannelOptions{Encodings: []string{"test-reverse"}})
+	res, err := feed.History(nil)
+	if err != nil {
+		t.Fatalf("History()=%v", err)
+	}
+	messages := res.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("want 2 messages; got %d", len(messages))
+	}
+	if m := messages[0]; m.Data != "data" || m.Encoding != "" || m.DecodeError != nil {
+		t.Errorf("want data=%q to be decoded; got data=%q encoding=%q (%v)", "data", m.Data, m.Encoding, m.DecodeError)
+	}
+	if m := messages[1]; m.Encoding != "utf-8/unknown" || m.DecodeError == nil {
+		t.Errorf("want message to be partially decoded; got encoding=%q (%v)", m.Encoding, m.DecodeError)
+	}
+}
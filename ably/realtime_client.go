@@ -46,6 +46,7 @@ func NewRealtimeClient(opts *ClientOptions) (*RealtimeClient, error) {
 
 // Close
 func (c *RealtimeClient) Close() error {
+	c.Auth.stop()
 	return c.Connection.Close()
 }
 
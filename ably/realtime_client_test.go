@@ -23,10 +23,8 @@ func TestRealtimeClient_RealtimeHost(t *testing.T) {
 		"localhost",
 		"::1",
 	}
-	stateRec := ablytest.NewStateRecorder(len(hosts))
 	for _, host := range hosts {
 		opts := rec.Options(host)
-		opts.Listener = stateRec.Channel()
 		client, err := ably.NewRealtimeClient(app.Options(opts))
 		if err != nil {
 			t.Errorf("NewRealtimeClient=%s (host=%s)", err, host)
@@ -36,42 +34,24 @@ func TestRealtimeClient_RealtimeHost(t *testing.T) {
 			t.Errorf("want state=%v; got %s", ably.StateConnInitialized, state)
 			continue
 		}
-		if err := checkError(80000, ablytest.Wait(client.Connection.Connect())); err != nil {
+		// Dialing an unreachable host is not fatal, the connection
+		// is retried as if it was disconnected.
+		if err := checkError(ably.ErrCodeDisconnected, ablytest.Wait(client.Connection.Connect())); err != nil {
 			t.Errorf("%s (host=%s)", err, host)
 			continue
 		}
-		if state := client.Connection.State(); state != ably.StateConnFailed {
-			t.Errorf("want state=%v; got %s", ably.StateConnFailed, state)
+		if state := client.Connection.State(); state == ably.StateConnFailed {
+			t.Errorf("want state other than %v", state)
 			continue
 		}
-		if err := checkError(80000, client.Close()); err != nil {
-			t.Errorf("%s (host=%s)", err, host)
+		if err := client.Close(); err != nil {
+			t.Errorf("Close()=%v (host=%s)", err, host)
 			continue
 		}
 		if _, ok := rec.Hosts[host]; !ok {
 			t.Errorf("host %s was not recorded (recorded %v)", host, rec.Hosts)
 		}
 	}
-	want := []ably.StateEnum{
-		ably.StateConnConnecting,
-		ably.StateConnFailed,
-		ably.StateConnConnecting,
-		ably.StateConnFailed,
-		ably.StateConnConnecting,
-		ably.StateConnFailed,
-	}
-	if err := stateRec.WaitFor(want); err != nil {
-		t.Fatal(err)
-	}
-	errors := stateRec.Errors()
-	if len(errors) != len(hosts) {
-		t.Fatalf("want len(errors)=%d; got %d", len(hosts), len(errors))
-	}
-	for i, err := range errors {
-		if err := checkError(80000, err); err != nil {
-			t.Errorf("%s (host=%s)", err, hosts[i])
-		}
-	}
 }
 
 func checkUnique(ch chan string, typ string, n int) error {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ably/ably-go/ably/internal/ablyutil"
@@ -14,6 +16,9 @@ import (
 var (
 	errQueueing      = errors.New("unable to send messages in current state with disabled queueing")
 	errCloseInactive = errors.New("attempted to close inactive connection")
+	errRecoveryKey   = errors.New("invalid recovery key")
+	errPingInactive  = errors.New("attempted to ping inactive connection")
+	errConnClosed    = errors.New("connection was closed")
 )
 
 // Conn represents a single connection RealtimeClient instantiates for
@@ -32,6 +37,13 @@ type Conn struct {
 	pending   pendingEmitter
 	queue     *msgQueue
 	auth      *Auth
+	retries   int           // number of consecutive reconnection attempts
+	suspendAt time.Time     // when reconnecting gives up; zero if not reconnecting
+	recover   string        // connection key to recover; empty once recovery was attempted
+	reauth    bool          // whether the token was renewed since last CONNECTED message
+	dialing   bool          // whether open is authorizing or dialing without c.state lock held
+	wake      chan struct{} // wakes the running reconnect loop; nil if there's none
+	pings     map[string]chan<- time.Time
 }
 
 func newConn(opts *ClientOptions, auth *Auth) (*Conn, error) {
@@ -41,8 +53,17 @@ func newConn(opts *ClientOptions, auth *Auth) (*Conn, error) {
 		state:   newStateEmitter(StateConn, StateConnInitialized, "", auth.logger()),
 		pending: newPendingEmitter(auth.logger()),
 		auth:    auth,
+		pings:   make(map[string]chan<- time.Time),
 	}
 	c.queue = newMsgQueue(c)
+	auth.sendAuth = c.sendAuth
+	if opts.Recover != "" {
+		key, serial, msgSerial, err := parseRecoveryKey(opts.Recover)
+		if err != nil {
+			return nil, newError(40003, err)
+		}
+		c.recover, c.serial, c.msgSerial = key, serial, msgSerial
+	}
 	if opts.Listener != nil {
 		c.On(opts.Listener)
 	}
@@ -58,7 +79,7 @@ func (c *Conn) dial(proto string, u *url.URL) (proto.Conn, error) {
 	if c.opts.Dial != nil {
 		return c.opts.Dial(proto, u)
 	}
-	return ablyutil.DialWebsocket(proto, u)
+	return ablyutil.DialWebsocket(proto, u, c.opts.timeoutConnect())
 }
 
 // Connect is used to connect to Ably servers manually, when the client owning
@@ -86,20 +107,54 @@ func (c *Conn) connect(result bool) (Result, error) {
 	if c.isActive() {
 		return nopResult, nil
 	}
-	c.state.set(StateConnConnecting, nil)
-	u, err := url.Parse(c.opts.realtimeURL())
-	if err != nil {
-		return nil, c.state.set(StateConnFailed, err)
-	}
+	c.retries, c.suspendAt = 0, time.Time{}
 	var res Result
 	if result {
 		res = c.state.listenResult(connectResultStates...)
 	}
-	proto := c.opts.protocol()
+	if c.state.current == StateConnDisconnected && c.wake != nil {
+		// The reconnect loop is waiting to retry, make it retry now
+		// instead of dialing concurrently with it.
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+		return res, nil
+	}
+	if err := c.open(); err != nil {
+		if c.state.current == StateConnFailed {
+			return nil, err
+		}
+		// Dialing failed, the connection is retried as if it was
+		// disconnected.
+		go c.reconnect(err)
+	}
+	return res, nil
+}
+
+// open dials new transport for the connection and starts processing messages
+// received from it.
+//
+// If dialing the primary realtime host fails, fallback hosts are tried in
+// random order. If building the request or authorizing it fails, open moves
+// the connection to StateConnFailed. Dial errors are returned to the caller
+// without changing the state, so it can retry the connection; dial timeouts
+// are reported with ErrCodeTimeout.
+//
+// It must be called with c.state lock held. The lock is released while
+// authorizing and dialing, so the connection may be closed in the meantime;
+// the new transport is then dropped and open returns nil.
+func (c *Conn) open() error {
+	c.state.set(StateConnConnecting, nil)
+	u, err := url.Parse(c.opts.realtimeURL())
+	if err != nil {
+		return c.state.set(StateConnFailed, err)
+	}
 	query := url.Values{
-		"timestamp": []string{strconv.FormatInt(TimeNow(), 10)},
-		"echo":      []string{"true"},
-		"format":    []string{"msgpack"},
+		"timestamp":  []string{strconv.FormatInt(TimeNow(), 10)},
+		"echo":       []string{"true"},
+		"format":     []string{"msgpack"},
+		"heartbeats": []string{"true"},
 	}
 	if c.opts.NoEcho {
 		query.Set("echo", "false")
@@ -107,23 +162,204 @@ func (c *Conn) connect(result bool) (Result, error) {
 	if c.opts.NoBinaryProtocol {
 		query.Set("format", "json")
 	}
+	switch {
+	case c.recover != "":
+		query.Set("recover", c.recover)
+		query.Set("connection_serial", strconv.FormatInt(c.serial, 10))
+	case c.id != "" && c.details.ConnectionKey != "":
+		query.Set("resume", c.details.ConnectionKey)
+		query.Set("connection_serial", strconv.FormatInt(c.serial, 10))
+	}
 	for k, v := range c.opts.TransportParams {
 		query.Set(k, v)
 	}
-	if err := c.auth.authQuery(query); err != nil {
-		return nil, c.state.set(StateConnFailed, err)
+	c.dialing = true
+	c.state.Unlock()
+	conn, err := c.authAndDial(u, query)
+	c.state.Lock()
+	c.dialing = false
+	if c.state.current != StateConnConnecting {
+		// Connection was closed while dialing.
+		if conn != nil {
+			conn.Close()
+		}
+		return nil
 	}
-	u.RawQuery = query.Encode()
-	conn, err := c.dial(proto, u)
 	if err != nil {
-		return nil, c.state.set(StateConnFailed, err)
+		if _, ok := err.(authError); ok {
+			return c.state.set(StateConnFailed, err.(authError).err)
+		}
+		return err
 	}
 	if c.logger().Is(LogVerbose) {
 		c.setConn(verboseConn{conn: conn, logger: c.logger()})
 	} else {
 		c.setConn(conn)
 	}
-	return res, nil
+	return nil
+}
+
+// authError wraps an error, which occurred while authorizing the connection
+// request, as opposed to dialing it.
+type authError struct {
+	err error
+}
+
+func (e authError) Error() string {
+	return e.err.Error()
+}
+
+// authAndDial authorizes the query and dials u with it, trying fallback
+// hosts if dialing the primary one fails. It's called without c.state
+// lock held.
+func (c *Conn) authAndDial(u *url.URL, query url.Values) (proto.Conn, error) {
+	if err := c.auth.authQuery(query); err != nil {
+		return nil, authError{err}
+	}
+	proto := c.opts.protocol()
+	u.RawQuery = query.Encode()
+	conn, err := c.dial(proto, u)
+	if err == nil {
+		return conn, nil
+	}
+	for _, host := range c.opts.realtimeFallbackHosts() {
+		c.logger().Printf(LogWarning, "Realtime Connection: dialing %s failed, trying fallback host %q: %v", u.Host, host, err)
+		if u, err = url.Parse(c.opts.realtimeHostURL(host)); err != nil {
+			return nil, authError{err}
+		}
+		u.RawQuery = query.Encode()
+		if conn, err = c.dial(proto, u); err == nil {
+			return conn, nil
+		}
+	}
+	if e := newError(ErrCodeConnectionFailed, err); e.Code == ErrCodeTimeout {
+		return nil, e
+	}
+	return nil, err
+}
+
+// reconnect re-establishes the connection after its transport failed with
+// the given err. First attempt is made immediately, the consecutive ones
+// are backed off exponentially up to TimeoutDisconnect. Once TimeoutSuspended
+// has passed since the connection was lost, the connection is moved to
+// StateConnSuspended and no more attempts are made.
+//
+// Only one reconnect loop runs at a time: starting a new one makes the
+// previous one stop once it wakes up. Connect wakes the running loop, so
+// it retries immediately.
+func (c *Conn) reconnect(err error) {
+	c.state.Lock()
+	wake := make(chan struct{}, 1)
+	c.wake = wake
+	for {
+		if c.suspendAt.IsZero() {
+			c.suspendAt = time.Now().Add(c.opts.timeoutSuspended())
+		}
+		if !time.Now().Before(c.suspendAt) {
+			c.wake = nil
+			err = c.state.set(StateConnSuspended, err)
+			c.state.Unlock()
+			c.queue.Fail(err)
+			return
+		}
+		delay := retryDelay(c.retries, c.opts.timeoutDisconnect())
+		c.retries++
+		c.state.setRetry(StateConnDisconnected, err, delay)
+		c.logger().Printf(LogInfo, "Realtime Connection: reconnecting in %v (attempt=%d): %v", delay, c.retries, err)
+		c.state.Unlock()
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-wake:
+			t.Stop()
+		}
+		c.state.Lock()
+		if c.wake != wake || c.state.current != StateConnDisconnected {
+			// Connection was either closed or connected explicitly
+			// in the meantime, or another loop took over.
+			if c.wake == wake {
+				c.wake = nil
+			}
+			c.state.Unlock()
+			return
+		}
+		if err = c.open(); err == nil || c.state.current == StateConnFailed {
+			c.wake = nil
+			c.state.Unlock()
+			return
+		}
+	}
+}
+
+// renewToken re-establishes the connection with a new token after Ably
+// rejected the current one with the given token error err.
+//
+// The connection is moved to StateConnFailed if the token is not renewable,
+// renewing it fails or Ably rejects also the renewed token before the
+// connection becomes connected.
+func (c *Conn) renewToken(err error) {
+	c.state.Lock()
+	switch c.state.current {
+	case StateConnClosing, StateConnClosed, StateConnFailed:
+		c.state.Unlock()
+		return
+	}
+	stale := c.auth.rejected(err)
+	if c.reauth || !c.auth.isTokenRenewable() {
+		err = c.state.set(StateConnFailed, err)
+		c.state.Unlock()
+		c.queue.Fail(err)
+		return
+	}
+	c.logger().Printf(LogInfo, "Realtime Connection: renewing token: %v", err)
+	c.state.Unlock()
+	_, e := c.auth.reauthorise(stale)
+	c.state.Lock()
+	switch c.state.current {
+	case StateConnClosing, StateConnClosed, StateConnFailed:
+		// Connection was closed while renewing the token.
+		c.state.Unlock()
+		return
+	}
+	if e != nil {
+		err = c.state.set(StateConnFailed, e)
+		c.state.Unlock()
+		c.queue.Fail(err)
+		return
+	}
+	c.reauth = true
+	if err = c.open(); err != nil {
+		if c.state.current == StateConnFailed {
+			c.state.Unlock()
+			c.queue.Fail(err)
+			return
+		}
+		c.state.Unlock()
+		c.reconnect(err)
+		return
+	}
+	c.state.Unlock()
+}
+
+// isTokenError reports whether the error received from Ably was caused by
+// an expired or otherwise invalid token, which can be fixed by renewing it.
+func isTokenError(err *proto.Error) bool {
+	return err != nil && err.StatusCode == 401 && err.Code >= 40140 && err.Code < 40150
+}
+
+// retryDelay gives the time to wait before n-th consecutive reconnection
+// attempt, which doubles with each attempt up to the given limit.
+func retryDelay(n int, limit time.Duration) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	if n > 16 {
+		return limit
+	}
+	if d := time.Second << uint(n-1); d < limit {
+		return d
+	}
+	return limit
 }
 
 // Close initiates closing sequence for the connection; it waits until the
@@ -149,13 +385,29 @@ var closeResultStates = []StateEnum{
 
 func (c *Conn) close() (Result, error) {
 	c.state.Lock()
-	defer c.state.Unlock()
 	switch c.state.current {
 	case StateConnClosing, StateConnClosed:
+		c.state.Unlock()
 		return nopResult, nil
-	case StateConnInitialized, StateConnFailed, StateConnDisconnected:
-		return nil, stateError(c.state.current, errCloseInactive)
+	case StateConnDisconnected, StateConnSuspended:
+		// There's no transport to send CLOSE over, stop reconnecting instead.
+		err := c.setClosed()
+		c.state.Unlock()
+		c.queue.Fail(err)
+		return nopResult, nil
+	case StateConnInitialized, StateConnFailed:
+		state := c.state.current
+		c.state.Unlock()
+		return nil, stateError(state, errCloseInactive)
 	}
+	if c.dialing {
+		// There's no transport yet, the one being dialed is dropped.
+		err := c.setClosed()
+		c.state.Unlock()
+		c.queue.Fail(err)
+		return nopResult, nil
+	}
+	defer c.state.Unlock()
 	res := c.state.listenResult(closeResultStates...)
 	c.state.set(StateConnClosing, nil)
 	msg := &proto.ProtocolMessage{Action: proto.ActionClose}
@@ -163,6 +415,28 @@ func (c *Conn) close() (Result, error) {
 	return res, c.conn.Send(msg)
 }
 
+// setClosed moves the connection to StateConnClosed. Messages waiting for
+// an ACK are failed and the connection details are dropped, so the next
+// Connect establishes a brand new connection instead of resuming this one.
+// The returned error is meant to fail queued messages with, which must be
+// done after c.state lock is released.
+//
+// It must be called with c.state lock held.
+func (c *Conn) setClosed() error {
+	err := newError(ErrCodeConnectionClosed, errConnClosed)
+	for _, sch := range c.pending.Reset() {
+		sch.ch <- err
+	}
+	if c.wake != nil {
+		close(c.wake)
+		c.wake = nil
+	}
+	c.id, c.details = "", proto.ConnectionDetails{}
+	c.serial, c.msgSerial = 0, 0
+	c.state.set(StateConnClosed, nil)
+	return err
+}
+
 // ID gives unique ID string obtained from Ably upon successful connection.
 // The ID may change due to reconnection and recovery; on every received
 // StateConnConnected event previously obtained ID is no longer valid.
@@ -181,13 +455,157 @@ func (c *Conn) Key() string {
 	return c.details.ConnectionKey
 }
 
+// RecoveryKey gives a string, which passed via ClientOptions.Recover to
+// a new RealtimeClient makes it recover the state of this connection, e.g.
+// after the process was restarted. The key is built from the connection key
+// and the serials of the most recently received and sent messages.
+//
+// The outcome of the recovery is reported with the StateConnConnected
+// transition: its Err is non-nil if the state could not be recovered and
+// a brand new connection was established instead.
+//
+// If the connection was never connected, RecoveryKey returns empty string.
+func (c *Conn) RecoveryKey() string {
+	c.state.Lock()
+	defer c.state.Unlock()
+	if c.details.ConnectionKey == "" {
+		return ""
+	}
+	return c.details.ConnectionKey + ":" + strconv.FormatInt(c.serial, 10) + ":" + strconv.FormatInt(c.msgSerial, 10)
+}
+
+func parseRecoveryKey(recoveryKey string) (key string, serial, msgSerial int64, err error) {
+	parts := strings.Split(recoveryKey, ":")
+	if len(parts) < 3 {
+		return "", 0, 0, errRecoveryKey
+	}
+	n := len(parts)
+	key = strings.Join(parts[:n-2], ":")
+	if serial, err = strconv.ParseInt(parts[n-2], 10, 64); err != nil {
+		return "", 0, 0, errRecoveryKey
+	}
+	if msgSerial, err = strconv.ParseInt(parts[n-1], 10, 64); err != nil || key == "" {
+		return "", 0, 0, errRecoveryKey
+	}
+	return key, serial, msgSerial, nil
+}
+
+var pingResultStates = []StateEnum{
+	StateConnConnected, // expected state
+	StateConnSuspended,
+	StateConnClosing,
+	StateConnClosed,
+	StateConnFailed,
+}
+
 // Ping issues a ping request against configured endpoint and returns TTR times
-// for ping request and pong response.
+// for ping request and pong response. The ping is the time it took to send
+// the heartbeat message, the pong is the time it took for the heartbeat
+// to be echoed back by Ably.
 //
 // Ping returns non-nil error without any attemp of communication with Ably
 // if the connection state is StateConnClosed or StateConnFailed.
+// If the connection is being (re)established, Ping waits for it to become
+// connected first. Ping fails if the response is not received within
+// TimeoutRequest.
 func (c *Conn) Ping() (ping, pong time.Duration, err error) {
-	return 0, 0, errors.New("TODO")
+	timeout := time.After(c.opts.timeoutRequest())
+	c.state.Lock()
+	switch state := c.state.current; state {
+	case StateConnConnected:
+	case StateConnConnecting, StateConnDisconnected:
+		res := c.state.listenResult(pingResultStates...)
+		c.state.Unlock()
+		done := make(chan error, 1)
+		go func() { done <- res.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				return 0, 0, err
+			}
+		case <-timeout:
+			return 0, 0, newErrorf(ErrCodeTimeout, "waiting for connection has timed out")
+		}
+		return c.ping(timeout)
+	default:
+		c.state.Unlock()
+		return 0, 0, stateError(state, errPingInactive)
+	}
+	c.state.Unlock()
+	return c.ping(timeout)
+}
+
+func (c *Conn) ping(timeout <-chan time.Time) (ping, pong time.Duration, err error) {
+	msg := &proto.ProtocolMessage{
+		Action: proto.ActionHeartbeat,
+		ID:     randomString(16),
+	}
+	received := make(chan time.Time, 1)
+	c.state.Lock()
+	if c.state.current != StateConnConnected {
+		state := c.state.current
+		c.state.Unlock()
+		return 0, 0, stateError(state, errPingInactive)
+	}
+	c.pings[msg.ID] = received
+	conn := c.conn
+	c.state.Unlock()
+	defer func() {
+		c.state.Lock()
+		delete(c.pings, msg.ID)
+		c.state.Unlock()
+	}()
+	start := time.Now()
+	if err := conn.Send(msg); err != nil {
+		return 0, 0, newError(ErrCodeConnectionFailed, err)
+	}
+	ping = time.Since(start)
+	select {
+	case t := <-received:
+		return ping, t.Sub(start), nil
+	case <-timeout:
+		return 0, 0, newErrorf(ErrCodeTimeout, "ping has timed out")
+	}
+}
+
+var authResultStates = []StateEnum{
+	StateConnConnected, // expected state
+	StateConnDisconnected,
+	StateConnSuspended,
+	StateConnClosed,
+	StateConnFailed,
+}
+
+// sendAuth reauthorises the connection in-band by sending the given token
+// in an AUTH message. It waits until Ably confirms the connection was updated
+// or TimeoutRequest passes.
+//
+// If the connection is not connected, sendAuth is a nop - the token is used
+// once the connection is (re)established.
+func (c *Conn) sendAuth(tok *TokenDetails) error {
+	c.state.Lock()
+	if c.state.current != StateConnConnected {
+		c.state.Unlock()
+		return nil
+	}
+	res := c.state.listenResult(authResultStates...)
+	conn := c.conn
+	c.state.Unlock()
+	msg := &proto.ProtocolMessage{
+		Action: proto.ActionAuth,
+		Auth:   &proto.AuthDetails{AccessToken: tok.Token},
+	}
+	if err := conn.Send(msg); err != nil {
+		return newError(ErrCodeConnectionFailed, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- res.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.opts.timeoutRequest()):
+		return newErrorf(ErrCodeTimeout, "waiting for reauthorisation has timed out")
+	}
 }
 
 // Reason gives last known error that caused connection transit to
@@ -238,7 +656,32 @@ func (c *Conn) updateSerial(msg *proto.ProtocolMessage, listen chan<- error) {
 	msg.MsgSerial = c.msgSerial
 	c.msgSerial = (c.msgSerial + 1) % maxint64
 	if listen != nil {
-		c.pending.Enqueue(msg.MsgSerial, listen)
+		c.pending.Enqueue(msg, listen)
+	}
+}
+
+// resendPending sends again messages which are still waiting for an ACK
+// after the transport was re-established. If the connection was resumed, the
+// messages keep their serials; otherwise they are sent as new ones.
+//
+// It must be called with c.state lock held.
+func (c *Conn) resendPending(resumed bool) {
+	if !resumed {
+		for _, sch := range c.pending.Reset() {
+			if err := c.verifyAndUpdateMessages(sch.msg); err != nil {
+				sch.ch <- err
+				continue
+			}
+			c.updateSerial(sch.msg, sch.ch)
+		}
+	}
+	for _, sch := range c.pending.queue {
+		if err := c.conn.Send(sch.msg); err != nil {
+			// The messages are going to be re-sent once the transport
+			// is re-established again.
+			c.logger().Printf(LogError, "failure re-sending message (serial=%d): %v", sch.serial, err)
+			return
+		}
 	}
 }
 
@@ -322,18 +765,33 @@ func (c *Conn) logger() *Logger {
 }
 
 func (c *Conn) eventloop() {
+	idle := newIdleTimer(c.conn)
+	defer idle.stop()
+	timeout := c.opts.timeoutConnect()
+	idle.start(timeout, newErrorf(ErrCodeTimeout, "connection was not established within %v", timeout))
 	for {
 		msg, err := c.conn.Receive()
 		if err != nil {
+			if e := idle.expired(); e != nil {
+				err = e
+			}
 			c.state.Lock()
-			if c.state.current == StateConnClosed {
+			switch c.state.current {
+			case StateConnClosing:
+				err := c.setClosed()
+				c.state.Unlock()
+				c.queue.Fail(err)
+				return
+			case StateConnClosed, StateConnFailed:
 				c.state.Unlock()
 				return
 			}
-			c.state.set(StateConnFailed, err)
 			c.state.Unlock()
-			return // TODO recovery
+			c.conn.Close()
+			c.reconnect(err)
+			return
 		}
+		idle.touch()
 		if msg.ConnectionSerial != 0 {
 			c.state.Lock()
 			c.serial = msg.ConnectionSerial
@@ -341,6 +799,15 @@ func (c *Conn) eventloop() {
 		}
 		switch msg.Action {
 		case proto.ActionHeartbeat:
+			if msg.ID == "" {
+				break
+			}
+			c.state.Lock()
+			if ch, ok := c.pings[msg.ID]; ok {
+				ch <- time.Now()
+				delete(c.pings, msg.ID)
+			}
+			c.state.Unlock()
 		case proto.ActionAck:
 			c.state.Lock()
 			c.pending.Ack(msg.MsgSerial, msg.Count, newErrorProto(msg.Error))
@@ -355,38 +822,171 @@ func (c *Conn) eventloop() {
 				c.msgCh <- msg
 				break
 			}
+			if isTokenError(msg.Error) {
+				c.conn.Close()
+				c.renewToken(newErrorProto(msg.Error))
+				return
+			}
 			c.state.Lock()
 			c.state.set(StateConnFailed, newErrorProto(msg.Error))
 			c.state.Unlock()
 			c.queue.Fail(newErrorProto(msg.Error))
 		case proto.ActionConnected:
 			c.auth.updateClientID(msg.ConnectionDetails.ClientID)
+			if msg.ConnectionDetails != nil && msg.ConnectionDetails.MaxIdleInterval > 0 {
+				maxIdle := time.Duration(msg.ConnectionDetails.MaxIdleInterval) * time.Millisecond
+				timeout := maxIdle + c.opts.timeoutIdleGrace()
+				idle.start(timeout, newErrorf(ErrCodeDisconnected, "no activity seen from Ably for %v", timeout))
+			} else {
+				idle.stop()
+			}
 			c.state.Lock()
+			if c.state.current == StateConnConnected {
+				// Connection was reauthorised in-band, only its details
+				// might have changed.
+				if msg.ConnectionDetails != nil {
+					c.details = *msg.ConnectionDetails
+				}
+				var err error
+				if msg.Error != nil {
+					err = newErrorProto(msg.Error)
+				}
+				c.state.update(err)
+				c.state.Unlock()
+				break
+			}
+			resuming := c.recover == "" && c.id != ""
+			resumed := resuming && c.id == msg.ConnectionID
 			c.id = msg.ConnectionID
 			if msg.ConnectionDetails != nil {
 				c.details = *msg.ConnectionDetails
 			}
-			c.serial = -1
-			c.msgSerial = 0
-			c.state.set(StateConnConnected, nil)
+			var err error
+			if msg.Error != nil {
+				err = newErrorProto(msg.Error)
+			}
+			switch {
+			case resumed:
+				// Connection was resumed, keep the serials and re-send messages
+				// which were not acknowledged before the transport was lost.
+				c.logger().Printf(LogInfo, "Realtime Connection: resumed connection %q", msg.ConnectionID)
+			case c.recover != "" && err == nil:
+				// Connection state was recovered, keep the serials.
+				c.logger().Printf(LogInfo, "Realtime Connection: recovered connection %q", msg.ConnectionID)
+			default:
+				if c.recover != "" {
+					c.logger().Printf(LogWarning, "Realtime Connection: unable to recover connection: %v", err)
+				} else if resuming {
+					c.logger().Printf(LogWarning, "Realtime Connection: unable to resume connection: %v", err)
+				}
+				c.serial = -1
+				c.msgSerial = 0
+			}
+			if resuming {
+				c.resendPending(resumed)
+			}
+			c.recover = ""
+			c.reauth = false
+			c.retries, c.suspendAt = 0, time.Time{}
+			c.state.set(StateConnConnected, err)
 			c.state.Unlock()
 			c.queue.Flush()
 		case proto.ActionDisconnected:
-			c.state.Lock()
-			c.id = ""
-			c.state.set(StateConnDisconnected, nil)
-			c.state.Unlock()
+			var err error
+			if msg.Error != nil {
+				err = newErrorProto(msg.Error)
+			}
+			c.conn.Close()
+			if isTokenError(msg.Error) {
+				c.renewToken(err)
+				return
+			}
+			c.reconnect(err)
+			return
 		case proto.ActionClosed:
 			c.state.Lock()
-			c.id = ""
-			c.state.set(StateConnClosed, nil)
+			err := c.setClosed()
 			c.state.Unlock()
+			c.queue.Fail(err)
 		default:
 			c.msgCh <- msg
 		}
 	}
 }
 
+// idleTimer closes the transport once no activity was seen on it for longer
+// than the timeout, which makes eventloop treat the connection as lost.
+// It guards both the CONNECTED handshake and the established connection.
+type idleTimer struct {
+	mtx     sync.Mutex
+	conn    proto.Conn
+	timer   *time.Timer
+	timeout time.Duration
+	last    time.Time // when last message was received
+	err     error     // reason of closing the transport after timeout
+	closed  bool      // whether the transport was closed due to inactivity
+}
+
+func newIdleTimer(conn proto.Conn) *idleTimer {
+	return &idleTimer{
+		conn: conn,
+		last: time.Now(),
+	}
+}
+
+// start begins watching the transport for inactivity; once it was idle for
+// longer than timeout, the transport is closed and err is reported by expired.
+func (t *idleTimer) start(timeout time.Duration, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.timeout = timeout
+	t.err = err
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(timeout-time.Since(t.last), t.check)
+}
+
+// touch records activity seen on the transport.
+func (t *idleTimer) touch() {
+	t.mtx.Lock()
+	t.last = time.Now()
+	t.mtx.Unlock()
+}
+
+func (t *idleTimer) check() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.timer == nil {
+		return
+	}
+	if d := time.Since(t.last); d < t.timeout {
+		t.timer.Reset(t.timeout - d)
+		return
+	}
+	t.closed = true
+	t.conn.Close()
+}
+
+func (t *idleTimer) stop() {
+	t.mtx.Lock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.mtx.Unlock()
+}
+
+// expired gives non-nil error if the transport was closed due to inactivity.
+func (t *idleTimer) expired() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.closed {
+		return nil
+	}
+	return t.err
+}
+
 type verboseConn struct {
 	conn   proto.Conn
 	logger *Logger
@@ -1,14 +1,182 @@
 package ably_test
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ably/ably-go/ably"
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
 )
 
+var errFakeClosed = errors.New("fake connection closed")
+
+// fakeConn is a proto.Conn which messages are injected and intercepted
+// by a test. Closing the connection makes pending Receive fail, which
+// simulates dropped transport.
+type fakeConn struct {
+	URL    *url.URL
+	in     chan *proto.ProtocolMessage
+	out    chan *proto.ProtocolMessage
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *fakeConn) Send(msg *proto.ProtocolMessage) error {
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.closed:
+		return errFakeClosed
+	}
+}
+
+func (c *fakeConn) Receive() (*proto.ProtocolMessage, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.closed:
+		return nil, errFakeClosed
+	}
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// Sent waits for the next message sent by the client.
+func (c *fakeConn) Sent() (*proto.ProtocolMessage, error) {
+	select {
+	case msg := <-c.out:
+		return msg, nil
+	case <-time.After(ablytest.Timeout):
+		return nil, fmt.Errorf("waiting for sent message has timed out after %v", ablytest.Timeout)
+	}
+}
+
+// ackClose replies with CLOSED message once the client requests closing
+// the connection.
+func (c *fakeConn) ackClose() {
+	for msg := range c.out {
+		if msg.Action == proto.ActionClose {
+			c.in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			return
+		}
+	}
+}
+
+// fakeDialer is used as ClientOptions.Dial, it hands out each new fakeConn
+// to the test via Next method. Dialing fails when Fail is non-nil.
+type fakeDialer struct {
+	mtx   sync.Mutex
+	fail  error
+	conns chan *fakeConn
+}
+
+func newFakeDialer() *fakeDialer {
+	return &fakeDialer{conns: make(chan *fakeConn, 16)}
+}
+
+func (d *fakeDialer) Dial(_ string, u *url.URL) (proto.Conn, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.fail != nil {
+		return nil, d.fail
+	}
+	conn := &fakeConn{
+		URL:    u,
+		in:     make(chan *proto.ProtocolMessage, 16),
+		out:    make(chan *proto.ProtocolMessage, 16),
+		closed: make(chan struct{}),
+	}
+	d.conns <- conn
+	return conn, nil
+}
+
+func (d *fakeDialer) Fail(err error) {
+	d.mtx.Lock()
+	d.fail = err
+	d.mtx.Unlock()
+}
+
+func (d *fakeDialer) Next() (*fakeConn, error) {
+	select {
+	case conn := <-d.conns:
+		return conn, nil
+	case <-time.After(ablytest.Timeout):
+		return nil, fmt.Errorf("waiting for dial has timed out after %v", ablytest.Timeout)
+	}
+}
+
+func fakeOptions(dialer *fakeDialer, opts *ably.ClientOptions) *ably.ClientOptions {
+	if opts == nil {
+		opts = &ably.ClientOptions{}
+	}
+	opts.Key = "fake.key:secret"
+	opts.NoConnect = true
+	opts.Dial = dialer.Dial
+	return opts
+}
+
+var fakeConnected = &proto.ProtocolMessage{
+	Action:            proto.ActionConnected,
+	ConnectionID:      "connection-id",
+	ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "connection-key"},
+}
+
+// connectedFakeClient gives a client connected over a fakeConn, which is
+// closed when the test finishes.
+func connectedFakeClient(t *testing.T, opts *ably.ClientOptions) (*ably.RealtimeClient, *fakeConn) {
+	dialer := newFakeDialer()
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, opts))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		go conn.ackClose()
+		if err := client.Close(); err != nil {
+			t.Errorf("Close()=%v", err)
+		}
+	})
+	return client, conn
+}
+
+// attachedFakeChannel gives the "feed" channel with the given options,
+// attached over a fakeConn of a connectedFakeClient.
+func attachedFakeChannel(t *testing.T, opts *ably.ChannelOptions) (*ably.RealtimeChannel, *fakeConn) {
+	client, conn := connectedFakeClient(t, nil)
+	feed := client.Channels.Get("feed", opts)
+	res, err := feed.Attach()
+	if err != nil {
+		t.Fatalf("Attach()=%v", err)
+	}
+	if msg, err := conn.Sent(); err != nil || msg.Action != proto.ActionAttach {
+		t.Fatalf("want ATTACH to be sent; got %v (%v)", msg, err)
+	}
+	conn.in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "feed"}
+	if err := res.Wait(); err != nil {
+		t.Fatalf("Attach()=%v", err)
+	}
+	return feed, conn
+}
+
 func await(fn func() ably.StateEnum, state ably.StateEnum) error {
 	t := time.After(ablytest.Timeout)
 	for {
@@ -135,3 +303,898 @@ func TestRealtimeConn_AuthError(t *testing.T) {
 		t.Fatal("Close(): want err != nil")
 	}
 }
+
+func TestRealtimeConn_Reconnect(t *testing.T) {
+	dialer := newFakeDialer()
+	rec := ablytest.NewStateConnRecorder(8)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		Listener: rec.Channel(),
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn.Close() // drop the transport
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	want := []ably.StateEnum{
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+		ably.StateConnDisconnected,
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+		ably.StateConnClosing,
+		ably.StateConnClosed,
+	}
+	if err := rec.WaitFor(want); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRealtimeConn_ReconnectSuspended(t *testing.T) {
+	dialer := newFakeDialer()
+	disconnected := make(chan ably.State, 64)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		TimeoutDisconnect: 10 * time.Millisecond,
+		TimeoutSuspended:  200 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	client.Connection.On(disconnected, ably.StateConnDisconnected)
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	dialer.Fail(errors.New("network is unreachable"))
+	conn.Close()
+	if err := await(client.Connection.State, ably.StateConnSuspended); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkError(80002, client.Connection.Reason()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(disconnected); n < 2 {
+		t.Fatalf("want at least 2 reconnection attempts; got %d", n)
+	}
+	if state := <-disconnected; state.RetryIn != 0 {
+		t.Fatalf("want first reconnection attempt to be immediate; got %v", state.RetryIn)
+	}
+	if state := <-disconnected; state.RetryIn != 10*time.Millisecond {
+		t.Fatalf("want RetryIn=%v; got %v", 10*time.Millisecond, state.RetryIn)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	if state := client.Connection.State(); state != ably.StateConnClosed {
+		t.Fatalf("want state=%s; got %s", ably.StateConnClosed, state)
+	}
+}
+
+func TestRealtimeConn_Recover(t *testing.T) {
+	dialer := newFakeDialer()
+	connected := make(chan ably.State, 1)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		Recover: "recovered-key:5:3",
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	client.Connection.On(connected, ably.StateConnConnected)
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := conn.URL.Query()
+	if key := query.Get("recover"); key != "recovered-key" {
+		t.Fatalf("want recover=%q; got %q", "recovered-key", key)
+	}
+	if serial := query.Get("connection_serial"); serial != "5" {
+		t.Fatalf("want connection_serial=%q; got %q", "5", serial)
+	}
+	conn.in <- fakeConnected
+	select {
+	case state := <-connected:
+		if state.Err != nil {
+			t.Fatalf("want recovery to succeed; got %v", state.Err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatalf("waiting for %s state has timed out", ably.StateConnConnected)
+	}
+	if key := client.Connection.RecoveryKey(); key != "connection-key:5:3" {
+		t.Fatalf("want RecoveryKey()=%q; got %q", "connection-key:5:3", key)
+	}
+}
+
+func TestRealtimeConn_RecoverFailed(t *testing.T) {
+	dialer := newFakeDialer()
+	connected := make(chan ably.State, 1)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		Recover: "recovered-key:5:3",
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	client.Connection.On(connected, ably.StateConnConnected)
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action:            proto.ActionConnected,
+		ConnectionID:      "connection-id",
+		ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "connection-key"},
+		Error:             &proto.Error{Code: 80008, StatusCode: 400, Message: "connection expired"},
+	}
+	select {
+	case state := <-connected:
+		if err := checkError(80008, state.Err); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatalf("waiting for %s state has timed out", ably.StateConnConnected)
+	}
+	if key := client.Connection.RecoveryKey(); key != "connection-key:-1:0" {
+		t.Fatalf("want RecoveryKey()=%q; got %q", "connection-key:-1:0", key)
+	}
+}
+
+func TestRealtimeConn_RecoverInvalidKey(t *testing.T) {
+	_, err := ably.NewRealtimeClient(fakeOptions(newFakeDialer(), &ably.ClientOptions{
+		Recover: "invalid-key",
+	}))
+	if err := checkError(40003, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publishUnacked connects the client and publishes a message, which is never
+// acknowledged over the returned connection.
+func publishUnacked(t *testing.T, dialer *fakeDialer, client *ably.RealtimeClient) (*fakeConn, *proto.ProtocolMessage, ably.Result) {
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Channels.Get("test").Publish("name", "value")
+	if err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+	if msg, err := conn.Sent(); err != nil || msg.Action != proto.ActionAttach {
+		t.Fatalf("want ATTACH to be sent; got %v (err=%v)", msg, err)
+	}
+	conn.in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	msg, err := conn.Sent()
+	if err != nil || msg.Action != proto.ActionMessage {
+		t.Fatalf("want MESSAGE to be sent; got %v (err=%v)", msg, err)
+	}
+	return conn, msg, res
+}
+
+func TestRealtimeConn_Resume(t *testing.T) {
+	dialer := newFakeDialer()
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, nil))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	conn, unacked, res := publishUnacked(t, dialer, client)
+	conn.Close() // drop the transport
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if key := conn.URL.Query().Get("resume"); key != "connection-key" {
+		t.Fatalf("want resume=%q; got %q", "connection-key", key)
+	}
+	conn.in <- fakeConnected
+	msg, err := conn.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Action != proto.ActionMessage || msg.MsgSerial != unacked.MsgSerial {
+		t.Fatalf("want MESSAGE with serial=%d to be re-sent; got %v", unacked.MsgSerial, msg)
+	}
+	conn.in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+	if state := client.Channels.Get("test").State(); state != ably.StateChanAttached {
+		t.Fatalf("want state=%s; got %s", ably.StateChanAttached, state)
+	}
+}
+
+func TestRealtimeConn_ResumeFailed(t *testing.T) {
+	dialer := newFakeDialer()
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, nil))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	conn, _, res := publishUnacked(t, dialer, client)
+	conn.Close() // drop the transport
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action:            proto.ActionConnected,
+		ConnectionID:      "new-connection-id",
+		ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "new-connection-key"},
+	}
+	sent := make(map[proto.Action]*proto.ProtocolMessage)
+	for i := 0; i < 2; i++ {
+		msg, err := conn.Sent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent[msg.Action] = msg
+	}
+	if _, ok := sent[proto.ActionAttach]; !ok {
+		t.Fatalf("want channel to be reattached; sent %v", sent)
+	}
+	if msg, ok := sent[proto.ActionMessage]; !ok || msg.MsgSerial != 0 {
+		t.Fatalf("want MESSAGE with serial=0 to be re-sent; sent %v", sent)
+	}
+	conn.in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 0, Count: 1}
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+}
+
+func TestRealtimeConn_Ping(t *testing.T) {
+	dialer := newFakeDialer()
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		TimeoutRequest: 100 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	if _, _, err := client.Connection.Ping(); err == nil {
+		t.Fatal("want Ping() to fail for never connected client")
+	}
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	echo := make(chan error, 1)
+	go func() {
+		msg, err := conn.Sent()
+		if err == nil && (msg.Action != proto.ActionHeartbeat || msg.ID == "") {
+			err = fmt.Errorf("want HEARTBEAT with non-empty ID; got %v", msg)
+		}
+		if err == nil {
+			conn.in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+			conn.in <- msg
+		}
+		echo <- err
+	}()
+	ping, pong, err := client.Connection.Ping()
+	if err != nil {
+		t.Fatalf("Ping()=%v", err)
+	}
+	if err := <-echo; err != nil {
+		t.Fatal(err)
+	}
+	if pong < ping {
+		t.Fatalf("want pong=%v to not be less than ping=%v", pong, ping)
+	}
+	// Ping without response times out.
+	_, _, err = client.Connection.Ping()
+	if err := checkError(50003, err); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.out
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	_, _, err = client.Connection.Ping()
+	if err := checkError(10000, err); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRealtimeConn_Idle(t *testing.T) {
+	dialer := newFakeDialer()
+	rec := ablytest.NewStateConnRecorder(8)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		Listener:         rec.Channel(),
+		TimeoutIdleGrace: 50 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := conn.URL.Query().Get("heartbeats"); s != "true" {
+		t.Fatalf("want heartbeats=true; got %q", s)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action:       proto.ActionConnected,
+		ConnectionID: "connection-id",
+		ConnectionDetails: &proto.ConnectionDetails{
+			ConnectionKey:   "connection-key",
+			MaxIdleInterval: 50,
+		},
+	}
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	// Heartbeats keep the transport alive past the idle timeout.
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		conn.in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+	}
+	if state := client.Connection.State(); state != ably.StateConnConnected {
+		t.Fatalf("want state=%v; got %v", ably.StateConnConnected, state)
+	}
+	// Without any activity the transport is dropped and connection reopened.
+	idle := conn
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-idle.closed:
+	default:
+		t.Fatal("want idle transport to be closed")
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	want := []ably.StateEnum{
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+		ably.StateConnDisconnected,
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+		ably.StateConnClosing,
+		ably.StateConnClosed,
+	}
+	if err := rec.WaitFor(want); err != nil {
+		t.Fatal(err)
+	}
+	if code := ably.ErrorCode(rec.Errors()[0]); code != ably.ErrCodeDisconnected {
+		t.Fatalf("want code=%d; got %d", ably.ErrCodeDisconnected, code)
+	}
+}
+
+func TestRealtimeConn_Fallback(t *testing.T) {
+	dialer := newFakeDialer()
+	var mtx sync.Mutex
+	var hosts []string
+	opts := fakeOptions(dialer, nil)
+	opts.Dial = func(typ string, u *url.URL) (proto.Conn, error) {
+		mtx.Lock()
+		hosts = append(hosts, u.Host)
+		mtx.Unlock()
+		if u.Host == "realtime.ably.io:443" {
+			return nil, errors.New("network is unreachable")
+		}
+		return dialer.Dial(typ, u)
+	}
+	client, err := ably.NewRealtimeClient(opts)
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.URL.Query().Get("key") == "" {
+		t.Fatalf("want fallback URL to carry query; got %q", conn.URL.RawQuery)
+	}
+	conn.in <- fakeConnected
+	if err := ablytest.Wait(res, nil); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	mtx.Lock()
+	got := append([]string(nil), hosts...)
+	mtx.Unlock()
+	if len(got) != 2 || !strings.HasSuffix(got[1], ".ably-realtime.com:443") {
+		t.Fatalf("want fallback host to be dialed; got %v", got)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_ConnectTimeout(t *testing.T) {
+	dialer := newFakeDialer()
+	rec := ablytest.NewStateConnRecorder(8)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		Listener:       rec.Channel(),
+		TimeoutConnect: 50 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No CONNECTED message is sent, so the handshake times out.
+	err = ablytest.Wait(res, nil)
+	if code := ably.ErrorCode(err); code != ably.ErrCodeTimeout {
+		t.Fatalf("want code=%d; got %d (%v)", ably.ErrCodeTimeout, code, err)
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatal("want timed out transport to be closed")
+	}
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	want := []ably.StateEnum{
+		ably.StateConnConnecting,
+		ably.StateConnDisconnected,
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+		ably.StateConnClosing,
+		ably.StateConnClosed,
+	}
+	if err := rec.WaitFor(want); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRealtimeConn_DialTimeout(t *testing.T) {
+	dialer := newFakeDialer()
+	dialer.Fail(timeoutError{})
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		FallbackHosts: []string{},
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	err = ablytest.Wait(res, nil)
+	if code := ably.ErrorCode(err); code != ably.ErrCodeTimeout {
+		t.Fatalf("want code=%d; got %d (%v)", ably.ErrCodeTimeout, code, err)
+	}
+	if state := client.Connection.State(); state != ably.StateConnDisconnected {
+		t.Fatalf("want state=%v; got %v", ably.StateConnDisconnected, state)
+	}
+	dialer.Fail(nil)
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_DialError(t *testing.T) {
+	dialer := newFakeDialer()
+	dialer.Fail(errors.New("connection refused"))
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		FallbackHosts: []string{},
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	if err := ablytest.Wait(res, nil); err == nil {
+		t.Fatal("want Connect() to fail")
+	}
+	if state := client.Connection.State(); state == ably.StateConnFailed {
+		t.Fatalf("want state other than %v", state)
+	}
+	dialer.Fail(nil)
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_CloseWhileAuthorizing(t *testing.T) {
+	dialer := newFakeDialer()
+	authorizing := make(chan struct{})
+	release := make(chan struct{})
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				close(authorizing)
+				<-release
+				return "token", nil
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Connection.Connect()
+		done <- err
+	}()
+	<-authorizing
+	states := make(chan ably.StateEnum, 1)
+	go func() { states <- client.Connection.State() }()
+	select {
+	case state := <-states:
+		if state != ably.StateConnConnecting {
+			t.Fatalf("want state=%v; got %v", ably.StateConnConnecting, state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("State() blocked while authorizing")
+	}
+	if err := client.Connection.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	if conn, err := dialer.Next(); err == nil {
+		select {
+		case <-conn.closed:
+		case <-time.After(time.Second):
+			t.Fatal("want transport dialed after Close() to be closed")
+		}
+	}
+	if state := client.Connection.State(); state != ably.StateConnClosed {
+		t.Fatalf("want state=%v; got %v", ably.StateConnClosed, state)
+	}
+}
+
+func TestRealtimeConn_CloseWhileReconnecting(t *testing.T) {
+	dialer := newFakeDialer()
+	disconnected := make(chan ably.State, 16)
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		FallbackHosts:     []string{},
+		TimeoutDisconnect: time.Minute,
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	client.Connection.On(disconnected, ably.StateConnDisconnected)
+	conn, _, unacked := publishUnacked(t, dialer, client)
+	dialer.Fail(errors.New("network is unreachable"))
+	conn.Close() // drop the transport
+	for state := range disconnected {
+		if state.RetryIn != 0 {
+			break // immediate reconnection attempt failed, waiting to retry
+		}
+	}
+	queued, err := client.Channels.Get("test").Publish("name", "queued")
+	if err != nil {
+		t.Fatalf("Publish()=%v", err)
+	}
+	if err := client.Connection.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+	for _, res := range []ably.Result{unacked, queued} {
+		if err := checkError(ably.ErrCodeConnectionClosed, ablytest.Wait(res, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id := client.Connection.ID(); id != "" {
+		t.Fatalf("want connection ID to be reset; got %q", id)
+	}
+	dialer.Fail(nil)
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if key := conn.URL.Query().Get("resume"); key != "" {
+		t.Fatalf("want closed connection not to be resumed; got resume=%q", key)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_ConnectWhileReconnecting(t *testing.T) {
+	dialer := newFakeDialer()
+	var mtx sync.Mutex
+	var dials int
+	opts := fakeOptions(dialer, &ably.ClientOptions{
+		FallbackHosts:     []string{},
+		TimeoutDisconnect: 100 * time.Millisecond,
+	})
+	opts.Dial = func(typ string, u *url.URL) (proto.Conn, error) {
+		mtx.Lock()
+		dials++
+		mtx.Unlock()
+		return dialer.Dial(typ, u)
+	}
+	client, err := ably.NewRealtimeClient(opts)
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	dialer.Fail(errors.New("connection refused"))
+	for i := 0; i < 5; i++ {
+		if _, err := client.Connection.Connect(); err != nil {
+			t.Fatalf("Connect()=%v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// A single reconnect loop dials about 10 times a second, each
+	// additional one would add as many.
+	mtx.Lock()
+	dials = 0
+	mtx.Unlock()
+	time.Sleep(time.Second)
+	mtx.Lock()
+	n := dials
+	mtx.Unlock()
+	if n > 15 {
+		t.Fatalf("want a single reconnect loop; got %d dials within a second", n)
+	}
+	dialer.Fail(nil)
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_RenewToken(t *testing.T) {
+	dialer := newFakeDialer()
+	var mtx sync.Mutex
+	var n int
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				n++
+				return fmt.Sprintf("token-%d", n), nil
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := conn.URL.Query().Get("access_token"); token != "token-1" {
+		t.Fatalf("want access_token=%q; got %q", "token-1", token)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action: proto.ActionDisconnected,
+		Error:  &proto.Error{StatusCode: 401, Code: ably.ErrCodeTokenExpired, Message: "token expired"},
+	}
+	if conn, err = dialer.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if token := conn.URL.Query().Get("access_token"); token != "token-2" {
+		t.Fatalf("want access_token=%q; got %q", "token-2", token)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
+
+func TestRealtimeConn_RenewTokenFailed(t *testing.T) {
+	dialer := newFakeDialer()
+	opts := fakeOptions(dialer, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Token: "fake-token"},
+	})
+	opts.Key = "" // token cannot be renewed without key or AuthCallback
+	client, err := ably.NewRealtimeClient(opts)
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	res, err := client.Connection.Connect()
+	if err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action: proto.ActionError,
+		Error:  &proto.Error{StatusCode: 401, Code: ably.ErrCodeTokenExpired, Message: "token expired"},
+	}
+	err = ablytest.Wait(res, nil)
+	if code := ably.ErrorCode(err); code != ably.ErrCodeTokenExpired {
+		t.Fatalf("want code=%d; got %d (%v)", ably.ErrCodeTokenExpired, code, err)
+	}
+	if state := client.Connection.State(); state != ably.StateConnFailed {
+		t.Fatalf("want state=%v; got %v", ably.StateConnFailed, state)
+	}
+}
+
+func TestRealtimeConn_AuthoriseInBand(t *testing.T) {
+	dialer := newFakeDialer()
+	var mtx sync.Mutex
+	var n int
+	client, err := ably.NewRealtimeClient(fakeOptions(dialer, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				n++
+				return fmt.Sprintf("token-%d", n), nil
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewRealtimeClient()=%v", err)
+	}
+	if _, err := client.Connection.Connect(); err != nil {
+		t.Fatalf("Connect()=%v", err)
+	}
+	conn, err := dialer.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.in <- fakeConnected
+	if err := await(client.Connection.State, ably.StateConnConnected); err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan ably.State, 1)
+	client.Connection.On(updates, ably.StateConnConnected)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Auth.Authorise(nil, &ably.AuthOptions{Force: true})
+		done <- err
+	}()
+	msg, err := conn.Sent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Action != proto.ActionAuth || msg.Auth == nil || msg.Auth.AccessToken != "token-2" {
+		t.Fatalf("want AUTH with access token %q; got %v", "token-2", msg)
+	}
+	conn.in <- &proto.ProtocolMessage{
+		Action:       proto.ActionConnected,
+		ConnectionID: "connection-id",
+		ConnectionDetails: &proto.ConnectionDetails{
+			ConnectionKey: "connection-key",
+			ClientID:      "new-client-id",
+		},
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Authorise()=%v", err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatalf("waiting for Authorise has timed out after %v", ablytest.Timeout)
+	}
+	select {
+	case state := <-updates:
+		if !state.Update {
+			t.Fatalf("want update event; got %+v", state)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatalf("waiting for update event has timed out after %v", ablytest.Timeout)
+	}
+	if id := client.Auth.ClientID(); id != "new-client-id" {
+		t.Fatalf("want ClientID=%q; got %q", "new-client-id", id)
+	}
+	if id := client.Connection.ID(); id != "connection-id" {
+		t.Fatalf("want ID=%q; got %q", "connection-id", id)
+	}
+	go conn.ackClose()
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close()=%v", err)
+	}
+}
@@ -21,7 +21,7 @@ const (
 // client or on behalf of other client.
 type RealtimePresence struct {
 	mtx       sync.Mutex
-	data      string
+	data      interface{}
 	serial    string
 	subs      *subscriptions
 	channel   *RealtimeChannel
@@ -62,6 +62,11 @@ func (pres *RealtimePresence) send(msg *proto.PresenceMessage) (Result, error) {
 	if err := pres.verifyChanState(); err != nil {
 		return nil, err
 	}
+	encoded, err := pres.channel.channelOpts().encode(&msg.Message, pres.channel.opts().protocol() == protocolMsgPack)
+	if err != nil {
+		return nil, err
+	}
+	msg.Message = *encoded
 	protomsg := &proto.ProtocolMessage{
 		Action:   proto.ActionPresence,
 		Channel:  pres.channel.state.channel,
@@ -224,7 +229,7 @@ func (pres *RealtimePresence) Unsubscribe(sub *Subscription, states ...proto.Pre
 
 // Enter announces presence of the current client with an enter message
 // for the associated channel.
-func (pres *RealtimePresence) Enter(data string) (Result, error) {
+func (pres *RealtimePresence) Enter(data interface{}) (Result, error) {
 	clientID := pres.auth().ClientID()
 	if clientID == "" {
 		return nil, newError(91000, nil)
@@ -236,7 +241,7 @@ func (pres *RealtimePresence) Enter(data string) (Result, error) {
 //
 // If the current client is not present on the channel, Update will
 // behave as Enter method.
-func (pres *RealtimePresence) Update(data string) (Result, error) {
+func (pres *RealtimePresence) Update(data interface{}) (Result, error) {
 	clientID := pres.auth().ClientID()
 	if clientID == "" {
 		return nil, newError(91000, nil)
@@ -246,7 +251,7 @@ func (pres *RealtimePresence) Update(data string) (Result, error) {
 
 // Leave announces current client leave the channel altogether with a leave
 // message if data is non-empty.
-func (pres *RealtimePresence) Leave(data string) (Result, error) {
+func (pres *RealtimePresence) Leave(data interface{}) (Result, error) {
 	clientID := pres.auth().ClientID()
 	if clientID == "" {
 		return nil, newError(91000, nil)
@@ -256,7 +261,10 @@ func (pres *RealtimePresence) Leave(data string) (Result, error) {
 
 // EnterClient announces presence of the given clientID altogether with an enter
 // message for the associated channel.
-func (pres *RealtimePresence) EnterClient(clientID, data string) (Result, error) {
+func (pres *RealtimePresence) EnterClient(clientID string, data interface{}) (Result, error) {
+	if err := pres.auth().checkCapability(pres.channel.Name, OpPresence); err != nil {
+		return nil, err
+	}
 	pres.mtx.Lock()
 	pres.data = data
 	pres.state = proto.PresenceEnter
@@ -275,12 +283,12 @@ func (pres *RealtimePresence) EnterClient(clientID, data string) (Result, error)
 //
 // If the given clientID is not present on the channel, Update will
 // behave as Enter method.
-func (pres *RealtimePresence) UpdateClient(clientID, data string) (Result, error) {
+func (pres *RealtimePresence) UpdateClient(clientID string, data interface{}) (Result, error) {
 	pres.mtx.Lock()
 	if pres.state != proto.PresenceEnter {
 		oldData := pres.data
 		pres.mtx.Unlock()
-		return pres.EnterClient(clientID, nonempty(data, oldData))
+		return pres.EnterClient(clientID, nonemptyData(data, oldData))
 	}
 	pres.data = data
 	pres.mtx.Unlock()
@@ -296,13 +304,13 @@ func (pres *RealtimePresence) UpdateClient(clientID, data string) (Result, error
 
 // LeaveClient announces the given clientID leave the associated channel altogether
 // with a leave message if data is non-empty.
-func (pres *RealtimePresence) LeaveClient(clientID, data string) (Result, error) {
+func (pres *RealtimePresence) LeaveClient(clientID string, data interface{}) (Result, error) {
 	pres.mtx.Lock()
 	if pres.state != proto.PresenceEnter {
 		pres.mtx.Unlock()
 		return nil, newError(91001, nil)
 	}
-	data = nonempty(data, pres.data)
+	data = nonemptyData(data, pres.data)
 	pres.data = data
 	pres.mtx.Unlock()
 	msg := &proto.PresenceMessage{
@@ -27,6 +27,7 @@ type RestChannel struct {
 
 	client  *RestClient
 	uriName string
+	options *ChannelOptions // guarded by client.chansMtx
 }
 
 func newRestChannel(name string, client *RestClient) *RestChannel {
@@ -42,17 +43,33 @@ func newRestChannel(name string, client *RestClient) *RestChannel {
 	return c
 }
 
-func (c *RestChannel) Publish(name string, data string) error {
+// Publish publishes a message with the given name and data, which is either
+// a string, []byte or a value that can be encoded as JSON.
+func (c *RestChannel) Publish(name string, data interface{}) error {
 	messages := []*proto.Message{
-		{Name: name, Data: data, Encoding: proto.UTF8},
+		{Name: name, Data: data},
 	}
 	return c.PublishAll(messages)
 }
 
+// PublishWithExtras is like Publish, but sends the message with the given
+// extras, e.g. a push notification payload or headers.
+func (c *RestChannel) PublishWithExtras(name string, data interface{}, extras *proto.MessageExtras) error {
+	msg, err := newMessage(name, data, extras)
+	if err != nil {
+		return err
+	}
+	return c.PublishAll([]*proto.Message{msg})
+}
+
 // PublishAll sends multiple messages in the same http call.
 // This is the more efficient way of transmitting a batch of messages
 // using the Rest API.
 func (c *RestChannel) PublishAll(messages []*proto.Message) error {
+	messages, err := c.opts().encodeAll(messages, c.client.opts.protocol() == protocolMsgPack)
+	if err != nil {
+		return err
+	}
 	res, err := c.client.post("/channels/"+c.uriName+"/messages", messages, nil)
 	if err != nil {
 		return err
@@ -64,8 +81,28 @@ func (c *RestChannel) PublishAll(messages []*proto.Message) error {
 // The returned result can be inspected for the messages via the Messages()
 // method.
 func (c *RestChannel) History(params *PaginateParams) (*PaginatedResult, error) {
+	return c.history(params, c.opts())
+}
+
+// history is like History, but decodes the messages with the given options
+// instead of the channel's ones.
+func (c *RestChannel) history(params *PaginateParams, opts *ChannelOptions) (*PaginatedResult, error) {
 	path := "/channels/" + c.uriName + "/history"
-	return newPaginatedResult(msgType, path, params, query(c.client.get), c.logger())
+	decode := func(items interface{}) {
+		opts.decodeAll(items, c.logger())
+	}
+	return newPaginatedResult(msgType, path, params, query(c.client.get), decode, c.logger())
+}
+
+// decode decodes messages or presence messages received from the channel.
+func (c *RestChannel) decode(items interface{}) {
+	c.opts().decodeAll(items, c.logger())
+}
+
+func (c *RestChannel) opts() *ChannelOptions {
+	c.client.chansMtx.Lock()
+	defer c.client.chansMtx.Unlock()
+	return c.options
 }
 
 func (c *RestChannel) logger() *Logger {
@@ -54,6 +54,14 @@ func NewRestClient(opts *ClientOptions) (*RestClient, error) {
 	return c, nil
 }
 
+// Close stops background token renewal enabled with TokenRenewalMargin.
+// The client can still be used afterwards, but its token is renewed only
+// once it has expired.
+func (c *RestClient) Close() error {
+	c.Auth.stop()
+	return nil
+}
+
 func (c *RestClient) Time() (time.Time, error) {
 	var times []int64
 	r := &request{
@@ -69,17 +77,23 @@ func (c *RestClient) Time() (time.Time, error) {
 	if len(times) != 1 {
 		return time.Time{}, newErrorf(50000, "expected 1 timestamp, got %d", len(times))
 	}
-	return time.Unix(times[0]/1000, times[0]%1000), nil
+	return time.Unix(times[0]/1000, times[0]%1000*int64(time.Millisecond)), nil
 }
 
-func (c *RestClient) Channel(name string) *RestChannel {
+// Channel looks up a channel given by the name and creates it if it does not
+// exist already. If opts are given, the last of them replaces the options
+// of the channel.
+func (c *RestClient) Channel(name string, opts ...*ChannelOptions) *RestChannel {
 	c.chansMtx.Lock()
 	defer c.chansMtx.Unlock()
-	if ch, ok := c.chans[name]; ok {
-		return ch
+	ch, ok := c.chans[name]
+	if !ok {
+		ch = newRestChannel(name, c)
+		c.chans[name] = ch
+	}
+	if len(opts) != 0 {
+		ch.options = opts[len(opts)-1]
 	}
-	ch := newRestChannel(name, c)
-	c.chans[name] = ch
 	return ch
 }
 
@@ -87,7 +101,7 @@ func (c *RestClient) Channel(name string) *RestChannel {
 // The returned result can be inspected for the statistics via the Stats()
 // method.
 func (c *RestClient) Stats(params *PaginateParams) (*PaginatedResult, error) {
-	return newPaginatedResult(statType, "/stats", params, query(c.get), c.logger())
+	return newPaginatedResult(statType, "/stats", params, query(c.get), nil, c.logger())
 }
 
 type request struct {
@@ -99,8 +113,19 @@ type request struct {
 	// NoAuth when set to true, makes the request not being authenticated.
 	NoAuth bool
 
+	// BasicAuth when set to true, makes the request authenticated with
+	// the key, regardless of the authentication method used by the client.
+	BasicAuth bool
+
 	// when true token is not refreshed when request fails with token expired response
 	NoRenew bool
+
+	// PartialOut, when non-nil, stores decoded body of 400 response to
+	// a batch request, which failed only for some of its items. The request
+	// then succeeds, so the caller can report outcomes for each item.
+	PartialOut interface{}
+
+	token *TokenDetails // token the request was authenticated with; nil for basic auth
 }
 
 func (c *RestClient) get(path string, out interface{}) (*http.Response, error) {
@@ -122,34 +147,85 @@ func (c *RestClient) post(path string, in, out interface{}) (*http.Response, err
 	return c.do(r)
 }
 
+// do issues the request against the primary REST host. If the host is
+// unreachable, times out or responds with a server error, the request is
+// retried against up to HTTPMaxRetryCount fallback hosts until one of them
+// succeeds or all of them fail. Failures of the client itself, e.g. when
+// authorizing the request, are not retried.
 func (c *RestClient) do(r *request) (*http.Response, error) {
-	req, err := c.newHTTPRequest(r)
+	resp, err := c.doWithHost(r, c.opts.restHost())
+	if _, ok := err.(hostError); ok {
+		hosts := c.opts.restFallbackHosts()
+		if n := c.opts.httpMaxRetryCount(); len(hosts) > n {
+			hosts = hosts[:n]
+		}
+		for _, host := range hosts {
+			c.logger().Printf(LogWarning, "RestClient: retrying %s %s with fallback host %q: %v", r.Method, r.Path, host, err)
+			resp, err = c.doWithHost(r, host)
+			if _, ok := err.(hostError); !ok {
+				break
+			}
+		}
+	}
+	if e, ok := err.(hostError); ok {
+		err = e.err
+	}
+	return resp, err
+}
+
+func (c *RestClient) doWithHost(r *request, host string) (*http.Response, error) {
+	req, err := c.newHTTPRequest(r, host)
 	if err != nil {
 		return nil, err
 	}
 	resp, err := c.opts.httpclient().Do(req)
 	if err != nil {
-		return nil, newError(50000, err)
+		return nil, hostError{newError(50000, err)}
 	}
+	if r.PartialOut != nil && resp.StatusCode == http.StatusBadRequest {
+		switch ok, err := decodePartial(resp, r.PartialOut); {
+		case err != nil:
+			return nil, err
+		case ok:
+			return resp, nil
+		}
+	}
+	status := resp.StatusCode
 	resp, err = c.handleResponse(resp, r.Out)
 	switch {
 	case err == nil:
 		return resp, nil
+	case status >= 500 && status <= 504:
+		return nil, hostError{err}
 	case code(err) == 40140:
+		if !r.NoAuth && !r.BasicAuth {
+			c.Auth.rejected(err)
+		}
 		if r.NoRenew || !c.Auth.isTokenRenewable() {
 			return nil, err
 		}
-		if _, err := c.Auth.reauthorise(); err != nil {
+		if _, err := c.Auth.reauthorise(r.token); err != nil {
 			return nil, err
 		}
 		r.NoRenew = true
-		return c.do(r)
+		return c.doWithHost(r, host)
 	default:
 		return nil, err
 	}
 }
 
-func (c *RestClient) newHTTPRequest(r *request) (*http.Request, error) {
+// hostError wraps an error caused by the host a request was sent to, which
+// was either unreachable or responded with a server error. Such request
+// is retried with a fallback host.
+type hostError struct {
+	err error
+}
+
+func (e hostError) Error() string {
+	return e.err.Error()
+}
+
+func (c *RestClient) newHTTPRequest(r *request, host string) (*http.Request, error) {
 	var body io.Reader
 	var proto = c.opts.protocol()
 	if r.In != nil {
@@ -159,7 +235,7 @@ func (c *RestClient) newHTTPRequest(r *request) (*http.Request, error) {
 		}
 		body = bytes.NewReader(p)
 	}
-	req, err := http.NewRequest(r.Method, c.opts.restURL()+r.Path, body)
+	req, err := http.NewRequest(r.Method, c.opts.restHostURL(host)+r.Path, body)
 	if err != nil {
 		return nil, newError(50000, err)
 	}
@@ -167,10 +243,19 @@ func (c *RestClient) newHTTPRequest(r *request) (*http.Request, error) {
 		req.Header.Set("Content-Type", proto)
 	}
 	req.Header.Set("Accept", proto)
-	if !r.NoAuth {
-		if err := c.Auth.authReq(req); err != nil {
+	switch {
+	case r.NoAuth:
+	case r.BasicAuth:
+		if c.opts.KeyName() == "" || c.opts.KeySecret() == "" {
+			return nil, newError(40101, errMissingKey)
+		}
+		req.SetBasicAuth(c.opts.KeyName(), c.opts.KeySecret())
+	default:
+		tok, err := c.Auth.authReq(req)
+		if err != nil {
 			return nil, err
 		}
+		r.token = tok
 	}
 	return req, nil
 }
@@ -223,6 +308,31 @@ func decode(typ string, r io.Reader, out interface{}) error {
 	}
 }
 
+// decodePartial decodes body of the response to a partially failed batch
+// request into out, reporting whether it was such a response. If it wasn't,
+// the body can still be read from the response.
+func decodePartial(resp *http.Response, out interface{}) (bool, error) {
+	p, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, newError(50000, err)
+	}
+	type batchBody struct {
+		BatchResponse interface{} `json:"batchResponse" msgpack:"batchResponse"`
+	}
+	var batch batchBody
+	resp.Body = ioutil.NopCloser(bytes.NewReader(p))
+	if err := decodeResp(resp, &batch); err != nil || batch.BatchResponse == nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(p))
+		return false, nil
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(p))
+	if err := decodeResp(resp, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func decodeResp(resp *http.Response, out interface{}) error {
 	defer resp.Body.Close()
 	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
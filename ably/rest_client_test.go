@@ -8,6 +8,8 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/ably/ably-go/ably"
@@ -197,3 +199,155 @@ var _ = Describe("RestClient", func() {
 		})
 	})
 })
+
+// hostRecorder is a fake Ably REST endpoint, which records hosts of received
+// requests and responds with server error for hosts listed in fail.
+type hostRecorder struct {
+	mtx   sync.Mutex
+	hosts []string
+	fail  map[string]bool
+}
+
+func (rec *hostRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mtx.Lock()
+	rec.hosts = append(rec.hosts, r.Host)
+	fail := rec.fail[r.Host]
+	rec.mtx.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, "[1000]")
+}
+
+func (rec *hostRecorder) Hosts() []string {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	return append([]string(nil), rec.hosts...)
+}
+
+func newHostRecorderClient(t *testing.T, rec *hostRecorder, opts *ably.ClientOptions) (*ably.RestClient, func()) {
+	srv := httptest.NewServer(rec)
+	if opts.AuthURL == "" {
+		opts.Token = "fake-token"
+	}
+	opts.NoTLS = true
+	opts.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) { return url.Parse(srv.URL) },
+		},
+	}
+	client, err := ably.NewRestClient(opts)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("NewRestClient()=%v", err)
+	}
+	return client, srv.Close
+}
+
+func TestRestClient_Fallback(t *testing.T) {
+	cases := []struct {
+		opts     *ably.ClientOptions
+		primary  string
+		fallback string // suffix of expected fallback host; empty if none
+	}{
+		{&ably.ClientOptions{}, "rest.ably.io", ".ably-realtime.com"},
+		{&ably.ClientOptions{Environment: "sandbox"}, "sandbox-rest.ably.io", "-fallback.ably-realtime.com"},
+		{&ably.ClientOptions{FallbackHosts: []string{"fallback.example.com"}}, "rest.ably.io", "fallback.example.com"},
+		{&ably.ClientOptions{FallbackHosts: []string{}}, "rest.ably.io", ""},
+		{&ably.ClientOptions{RestHost: "rest.example.com"}, "rest.example.com", ""},
+	}
+	for i, cas := range cases {
+		rec := &hostRecorder{fail: map[string]bool{cas.primary: true}}
+		client, done := newHostRecorderClient(t, rec, cas.opts)
+		_, err := client.Time()
+		done()
+		hosts := rec.Hosts()
+		if len(hosts) == 0 || hosts[0] != cas.primary {
+			t.Errorf("%d: want first host=%q; got %v", i, cas.primary, hosts)
+			continue
+		}
+		if cas.fallback == "" {
+			if err == nil {
+				t.Errorf("%d: want Time() to fail", i)
+			}
+			if len(hosts) != 1 {
+				t.Errorf("%d: want no fallback hosts to be used; got %v", i, hosts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Time()=%v", i, err)
+			continue
+		}
+		if len(hosts) != 2 || !strings.HasSuffix(hosts[1], cas.fallback) {
+			t.Errorf("%d: want fallback host *%s; got %v", i, cas.fallback, hosts)
+		}
+	}
+}
+
+func TestRestClient_FallbackAllFail(t *testing.T) {
+	fallback := []string{"a.example.com", "b.example.com", "c.example.com"}
+	rec := &hostRecorder{fail: map[string]bool{"rest.ably.io": true}}
+	for _, host := range fallback {
+		rec.fail[host] = true
+	}
+	client, done := newHostRecorderClient(t, rec, &ably.ClientOptions{FallbackHosts: fallback})
+	defer done()
+	if _, err := client.Time(); err == nil {
+		t.Fatal("want Time() to fail")
+	}
+	if hosts := rec.Hosts(); len(hosts) != 1+len(fallback) {
+		t.Fatalf("want %d requests; got %v", 1+len(fallback), hosts)
+	}
+}
+
+func TestRestClient_FallbackMaxRetryCount(t *testing.T) {
+	fallback := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com"}
+	cases := []struct {
+		retries int
+		want    int // number of requested hosts
+	}{
+		{0, 4},
+		{2, 3},
+		{10, 6},
+	}
+	for _, cas := range cases {
+		rec := &hostRecorder{fail: map[string]bool{"rest.ably.io": true}}
+		for _, host := range fallback {
+			rec.fail[host] = true
+		}
+		client, done := newHostRecorderClient(t, rec, &ably.ClientOptions{
+			FallbackHosts:     fallback,
+			HTTPMaxRetryCount: cas.retries,
+		})
+		_, err := client.Time()
+		done()
+		if err == nil {
+			t.Errorf("HTTPMaxRetryCount=%d: want Time() to fail", cas.retries)
+		}
+		if hosts := rec.Hosts(); len(hosts) != cas.want {
+			t.Errorf("HTTPMaxRetryCount=%d: want %d requests; got %v", cas.retries, cas.want, hosts)
+		}
+	}
+}
+
+func TestRestClient_FallbackAuthError(t *testing.T) {
+	rec := &hostRecorder{fail: map[string]bool{"auth.example.com": true}}
+	client, done := newHostRecorderClient(t, rec, &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			AuthURL: "http://auth.example.com/token",
+		},
+		FallbackHosts: []string{"a.example.com", "b.example.com"},
+	})
+	defer done()
+	if _, err := client.Stats(nil); err == nil {
+		t.Fatal("want Stats() to fail")
+	}
+	// Failing to authorize the request is not a failure of the REST host,
+	// so neither the request is retried nor the auth URL requested again.
+	if hosts := rec.Hosts(); len(hosts) != 1 || hosts[0] != "auth.example.com" {
+		t.Fatalf("want auth URL to be requested once; got %v", hosts)
+	}
+}
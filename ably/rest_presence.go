@@ -10,7 +10,7 @@ type RestPresence struct {
 // the PresenceMessages() method.
 func (p *RestPresence) Get(params *PaginateParams) (*PaginatedResult, error) {
 	path := "/channels/" + p.channel.uriName + "/presence"
-	return newPaginatedResult(presMsgType, path, params, query(p.client.get), p.logger())
+	return newPaginatedResult(presMsgType, path, params, query(p.client.get), p.channel.decode, p.logger())
 }
 
 // History gives the channel's presence messages history according to the given
@@ -18,7 +18,7 @@ func (p *RestPresence) Get(params *PaginateParams) (*PaginatedResult, error) {
 // via the PresenceMessages() method.
 func (p *RestPresence) History(params *PaginateParams) (*PaginatedResult, error) {
 	path := "/channels/" + p.channel.uriName + "/presence/history"
-	return newPaginatedResult(presMsgType, path, params, query(p.client.get), p.logger())
+	return newPaginatedResult(presMsgType, path, params, query(p.client.get), p.channel.decode, p.logger())
 }
 
 func (p *RestPresence) logger() *Logger {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ably/ably-go/ably/proto"
 )
@@ -183,10 +184,12 @@ func stateError(state StateEnum, err error) error {
 // a channel, which will get notified with single State value for each transition
 // than takes place.
 type State struct {
-	Channel string    // channel name or empty if Type is StateConn
-	Err     error     // eventual error value associated with transition
-	State   StateEnum // state which connection or channel has transitioned to
-	Type    StateType // whether transition happened on connection or channel
+	Channel string        // channel name or empty if Type is StateConn
+	Err     error         // eventual error value associated with transition
+	State   StateEnum     // state which connection or channel has transitioned to
+	Type    StateType     // whether transition happened on connection or channel
+	RetryIn time.Duration // for StateConnDisconnected, time left till next reconnection attempt
+	Update  bool          // whether the state was not left, but its details have changed
 }
 
 type stateEmitter struct {
@@ -215,6 +218,12 @@ func newStateEmitter(typ StateType, startState StateEnum, channel string, log *L
 }
 
 func (s *stateEmitter) set(state StateEnum, err error) error {
+	return s.setRetry(state, err, 0)
+}
+
+// setRetry is like set, but the emitted transition also carries the duration
+// after which the next attempt of leaving the state is going to be made.
+func (s *stateEmitter) setRetry(state StateEnum, err error, retryIn time.Duration) error {
 	doemit := s.current != state
 	s.current = state
 	s.err = stateError(state, err)
@@ -224,11 +233,26 @@ func (s *stateEmitter) set(state StateEnum, err error) error {
 			Err:     s.err,
 			State:   s.current,
 			Type:    s.typ,
+			RetryIn: retryIn,
 		})
 	}
 	return s.err
 }
 
+// update notifies listeners of the current state that details associated
+// with it have changed, without transitioning to another state.
+func (s *stateEmitter) update(err error) error {
+	s.err = stateError(s.current, err)
+	s.emit(State{
+		Channel: s.channel,
+		Err:     s.err,
+		State:   s.current,
+		Type:    s.typ,
+		Update:  true,
+	})
+	return s.err
+}
+
 func (s *stateEmitter) emit(st State) {
 	for ch := range s.listeners[st.State] {
 		select {
@@ -329,6 +353,7 @@ func newPendingEmitter(log *Logger) pendingEmitter {
 
 type serialCh struct {
 	serial int64
+	msg    *proto.ProtocolMessage
 	ch     chan<- error
 }
 
@@ -348,19 +373,28 @@ func (q pendingEmitter) Search(serial int64) int {
 	return sort.Search(q.Len(), func(i int) bool { return q.queue[i].serial >= serial })
 }
 
-func (q *pendingEmitter) Enqueue(serial int64, ch chan<- error) {
+func (q *pendingEmitter) Enqueue(msg *proto.ProtocolMessage, ch chan<- error) {
+	serial := msg.MsgSerial
 	switch i := q.Search(serial); {
 	case i == q.Len():
-		q.queue = append(q.queue, serialCh{serial, ch})
+		q.queue = append(q.queue, serialCh{serial, msg, ch})
 	case q.queue[i].serial == serial:
 		q.logger.Printf(LogWarning, "duplicated message serial: %d", serial)
 	default:
 		q.queue = append(q.queue, serialCh{})
 		copy(q.queue[i+1:], q.queue[i:])
-		q.queue[i] = serialCh{serial, ch}
+		q.queue[i] = serialCh{serial, msg, ch}
 	}
 }
 
+// Reset removes all messages awaiting confirmation from the queue and returns
+// them in the order they were sent.
+func (q *pendingEmitter) Reset() []serialCh {
+	queue := q.queue
+	q.queue = nil
+	return queue
+}
+
 func (q *pendingEmitter) Ack(serial int64, count int, err error) {
 	if q.Len() == 0 {
 		return
@@ -451,11 +485,19 @@ func (q *msgQueue) Flush() {
 	q.mtx.Unlock()
 }
 
+// Fail fails all queued messages with the given err. If err is not *Error
+// already, it's reported with 90000 code.
 func (q *msgQueue) Fail(err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = newError(90000, err)
+	}
 	q.mtx.Lock()
 	for _, msgch := range q.queue {
 		q.logger().Printf(LogError, "failure sending message (serial=%d): %v", msgch.msg.MsgSerial, err)
-		msgch.ch <- newError(90000, err)
+		if msgch.ch != nil {
+			msgch.ch <- e
+		}
 	}
 	q.queue = nil
 	q.mtx.Unlock()
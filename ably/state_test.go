@@ -3,6 +3,8 @@ package ably
 import (
 	"errors"
 	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
 )
 
 var errNotEmitted = errors.New("not emitted")
@@ -42,7 +44,7 @@ func testQueuedEmitter(t *testing.T, serials, ack, nack []int64, emit func(*pend
 	}
 	q := &pendingEmitter{logger: &Logger{}}
 	for serial, i := range index {
-		q.Enqueue(serial, ch[i])
+		q.Enqueue(&proto.ProtocolMessage{MsgSerial: serial}, ch[i])
 	}
 	emit(q)
 	errs := receive(ch...)
@@ -8,17 +8,101 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// Capability
+// Operation is an operation on a resource, which access to is granted
+// by a Capability.
+type Operation string
+
+const (
+	OpAll             Operation = "*"
+	OpPublish         Operation = "publish"
+	OpSubscribe       Operation = "subscribe"
+	OpPresence        Operation = "presence"
+	OpHistory         Operation = "history"
+	OpStats           Operation = "stats"
+	OpChannelMetadata Operation = "channel-metadata"
+	OpPushSubscribe   Operation = "push-subscribe"
+	OpPushAdmin       Operation = "push-admin"
+)
+
+// Capability maps resources, like channel names, to operations allowed
+// on them.
+//
+// A resource "*" matches all channels, a resource "namespace:*" matches all
+// channels in the namespace. Any other resource matches only the channel
+// of the same name, e.g. "chat*" does not match "chatter".
 type Capability map[string][]string
 
-// ParseCapability
+// ParseCapability decodes the given JSON-encoded capability. It returns
+// non-nil error, if the capability is malformed or it's invalid according
+// to Validate, in which case the decoded capability is returned as well.
 func ParseCapability(capability string) (c Capability, err error) {
 	c = make(Capability)
-	err = json.Unmarshal([]byte(capability), &c)
-	return
+	if err = json.Unmarshal([]byte(capability), &c); err != nil {
+		return c, newError(40003, err)
+	}
+	return c, c.Validate()
+}
+
+// Grant adds the given operations to the ones allowed on the resource
+// and returns c. If c is nil, a new Capability is allocated and returned
+// instead, so the result must be used, e.g. c = c.Grant("feed", OpPublish).
+func (c Capability) Grant(resource string, ops ...Operation) Capability {
+	if c == nil {
+		c = make(Capability)
+	}
+	for _, op := range ops {
+		c[resource] = append(c[resource], string(op))
+	}
+	return c
+}
+
+// Validate returns non-nil error if any of the resources is empty or has
+// no operations. Operations unknown to the library are accepted, as Ably
+// may grant ones added after the library was released.
+func (c Capability) Validate() error {
+	for resource, ops := range c {
+		if resource == "" {
+			return newErrorf(40003, "capability has an empty resource")
+		}
+		if len(ops) == 0 {
+			return newErrorf(40003, "capability has no operations for resource %q", resource)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the capability grants the given operation on
+// the resource.
+func (c Capability) Allows(resource string, op Operation) bool {
+	for r, ops := range c {
+		if !matchResource(r, resource) {
+			continue
+		}
+		for _, o := range ops {
+			if Operation(o) == OpAll || Operation(o) == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchResource reports whether the resource pattern from capability matches
+// the given resource name. The pattern may be prefixed with a "[*]" qualifier.
+func matchResource(pattern, resource string) bool {
+	pattern = strings.TrimPrefix(pattern, "[*]")
+	switch {
+	case pattern == "*" || pattern == resource:
+		return true
+	case strings.HasSuffix(pattern, ":*"):
+		return strings.HasPrefix(resource, pattern[:len(pattern)-1])
+	default:
+		return false
+	}
 }
 
 // Encode
@@ -52,12 +136,19 @@ type TokenParams struct {
 	Timestamp int64 `json:"timestamp,omitempty" msgpack:"timestamp,omitempty"`
 }
 
-// Capability
+// Capability decodes RawCapability. If it's malformed, the error is ignored;
+// use ParseCapability to detect it.
 func (params *TokenParams) Capability() Capability {
 	c, _ := ParseCapability(params.RawCapability)
 	return c
 }
 
+// ParseCapability decodes RawCapability with ParseCapability. If RawCapability
+// is empty, both the capability and the error are nil.
+func (params *TokenParams) ParseCapability() (Capability, error) {
+	return parseRawCapability(params.RawCapability)
+}
+
 // Query encodes the params to query params value. If a field of params is
 // a zero-value, it's omitted. If params is zero-value, nil is returned.
 func (params *TokenParams) Query() url.Values {
@@ -121,13 +212,29 @@ type TokenDetails struct {
 	RawCapability string `json:"capability,omitempty" msgpack:"capability,omitempty"`
 }
 
-// Capability
+// Capability decodes RawCapability. If it's malformed, the error is ignored;
+// use ParseCapability to detect it.
 func (tok *TokenDetails) Capability() Capability {
 	c, _ := ParseCapability(tok.RawCapability)
 	return c
 }
 
-// Expired
+// ParseCapability decodes RawCapability with ParseCapability. If RawCapability
+// is empty, both the capability and the error are nil.
+func (tok *TokenDetails) ParseCapability() (Capability, error) {
+	return parseRawCapability(tok.RawCapability)
+}
+
+func parseRawCapability(raw string) (Capability, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	return ParseCapability(raw)
+}
+
+// Expired reports whether the token has expired according to the local
+// clock. Auth accounts for the offset between local and server clocks
+// on its own.
 func (tok *TokenDetails) Expired() bool {
 	return tok.Expires != 0 && tok.Expires <= TimeNow()
 }
@@ -140,8 +247,13 @@ func (tok *TokenDetails) ExpireTime() time.Time {
 	return time.Unix(tok.Expires/1000, tok.Expires%1000*int64(time.Millisecond))
 }
 
+// newTokenDetails wraps the given token string. If the token is an Ably JWT,
+// its expiry time, capability and ClientID are read from its claims; otherwise
+// the token is opaque and its expiry time is unknown.
 func newTokenDetails(token string) *TokenDetails {
-	return &TokenDetails{
+	tok := &TokenDetails{
 		Token: token,
 	}
+	parseJWT(token, tok)
+	return tok
 }
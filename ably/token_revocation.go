@@ -0,0 +1,138 @@
+package ably
+
+import (
+	"errors"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+var errMissingTargets = errors.New("no token revocation targets were given")
+
+// TokenRevocationTarget selects tokens to be revoked.
+type TokenRevocationTarget struct {
+	Type  string // type of the specifier, e.g. "clientId" or "revocationKey"
+	Value string // value the tokens are matched against
+}
+
+// ClientIDTarget selects all tokens issued to the given ClientID.
+func ClientIDTarget(clientID string) TokenRevocationTarget {
+	return TokenRevocationTarget{Type: "clientId", Value: clientID}
+}
+
+// RevocationKeyTarget selects all tokens issued with the given revocation key.
+func RevocationKeyTarget(key string) TokenRevocationTarget {
+	return TokenRevocationTarget{Type: "revocationKey", Value: key}
+}
+
+// String gives the target specifier in the "type:value" form.
+func (t TokenRevocationTarget) String() string {
+	return t.Type + ":" + t.Value
+}
+
+// TokenRevocationOptions
+type TokenRevocationOptions struct {
+	// IssuedBefore, when non-zero, limits revocation to tokens issued before
+	// the given time, in milliseconds since epoch. Ably uses the current
+	// time otherwise.
+	IssuedBefore int64
+
+	// AllowReauthMargin delays enforcement of the revocation by 30 seconds,
+	// which gives connected clients time to obtain new tokens before being
+	// disconnected.
+	AllowReauthMargin bool
+}
+
+// TokenRevocationResult describes the outcome of revocation for one target.
+type TokenRevocationResult struct {
+	Target       string // target specifier in the "type:value" form
+	AppliesAt    int64  // time the revocation is enforced at, in milliseconds since epoch
+	IssuedBefore int64  // tokens issued before that time are revoked, in milliseconds since epoch
+	Err          error  // non-nil if revocation for the target failed
+}
+
+type tokenRevocationRequest struct {
+	Targets           []string `json:"targets" msgpack:"targets"`
+	IssuedBefore      int64    `json:"issuedBefore,omitempty" msgpack:"issuedBefore,omitempty"`
+	AllowReauthMargin bool     `json:"allowReauthMargin,omitempty" msgpack:"allowReauthMargin,omitempty"`
+}
+
+type tokenRevocationResponse struct {
+	Target       string       `json:"target,omitempty" msgpack:"target,omitempty"`
+	AppliesAt    int64        `json:"appliesAt,omitempty" msgpack:"appliesAt,omitempty"`
+	IssuedBefore int64        `json:"issuedBefore,omitempty" msgpack:"issuedBefore,omitempty"`
+	Error        *proto.Error `json:"error,omitempty" msgpack:"error,omitempty"`
+}
+
+// tokenRevocationBatch is a body of the response to the revocation request,
+// which failed only for some of the targets.
+type tokenRevocationBatch struct {
+	Error         *proto.Error              `json:"error,omitempty" msgpack:"error,omitempty"`
+	BatchResponse []tokenRevocationResponse `json:"batchResponse,omitempty" msgpack:"batchResponse,omitempty"`
+}
+
+// RevokeTokens revokes all tokens issued with the client's key, which match
+// any of the given targets. The request is authenticated with the key even
+// if the client uses token authentication.
+//
+// The returned error is non-nil only if the request as a whole failed.
+// Otherwise there's a result for each of the targets the response reports
+// on, in the order they were given, which reports the failure for
+// the individual target, so the failed targets can be retried. Targets
+// missing from the response are left out of the results, as it's unknown
+// whether they were revoked; they're safe to retry too.
+func (a *Auth) RevokeTokens(targets []TokenRevocationTarget, opts *TokenRevocationOptions) ([]*TokenRevocationResult, error) {
+	if len(targets) == 0 {
+		return nil, newError(40000, errMissingTargets)
+	}
+	keyName := a.opts().KeyName()
+	if keyName == "" {
+		return nil, newError(40101, errMissingKey)
+	}
+	in := &tokenRevocationRequest{}
+	for _, t := range targets {
+		in.Targets = append(in.Targets, t.String())
+	}
+	if opts != nil {
+		in.IssuedBefore = opts.IssuedBefore
+		in.AllowReauthMargin = opts.AllowReauthMargin
+	}
+	var out []tokenRevocationResponse
+	var partial tokenRevocationBatch
+	r := &request{
+		Method:     "POST",
+		Path:       "/keys/" + keyName + "/revokeTokens",
+		In:         in,
+		Out:        &out,
+		PartialOut: &partial,
+		BasicAuth:  true,
+		NoRenew:    true,
+	}
+	if _, err := a.client.do(r); err != nil {
+		return nil, err
+	}
+	if partial.BatchResponse != nil {
+		out = partial.BatchResponse
+	}
+	byTarget := make(map[string]tokenRevocationResponse, len(out))
+	for _, o := range out {
+		byTarget[o.Target] = o
+	}
+	results := make([]*TokenRevocationResult, 0, len(targets))
+	for _, t := range in.Targets {
+		o, ok := byTarget[t]
+		if !ok {
+			continue
+		}
+		res := &TokenRevocationResult{Target: t}
+		if o.Error != nil {
+			// The response as a whole succeeded, so an error without
+			// code is assumed to be caused by the target itself.
+			res.Err = errorFromProto(o.Error, 400)
+		} else {
+			res.AppliesAt = o.AppliesAt
+			res.IssuedBefore = o.IssuedBefore
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,98 @@
+package ably
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TokenStore persists tokens obtained by Auth, so they can be reused by
+// a client created later, e.g. after the process was restarted.
+type TokenStore interface {
+	// Load gives the most recently saved token; it gives nil token and nil
+	// error if there is no token saved.
+	Load() (*TokenDetails, error)
+
+	// Save stores the given token, replacing the one saved previously.
+	Save(tok *TokenDetails) error
+}
+
+// MemoryTokenStore is a TokenStore, which keeps the token in memory. It can
+// be shared by clients within the same process.
+type MemoryTokenStore struct {
+	mtx sync.Mutex
+	tok *TokenDetails
+}
+
+// Load implements the TokenStore interface.
+func (s *MemoryTokenStore) Load() (*TokenDetails, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.tok, nil
+}
+
+// Save implements the TokenStore interface.
+func (s *MemoryTokenStore) Save(tok *TokenDetails) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.tok = tok
+	return nil
+}
+
+// FileTokenStore is a TokenStore, which keeps JSON-encoded token in a file
+// readable only by its owner.
+type FileTokenStore struct {
+	Path string // path of the file
+
+	mtx sync.Mutex
+}
+
+// NewFileTokenStore gives new FileTokenStore for the given path.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load implements the TokenStore interface.
+func (s *FileTokenStore) Load() (*TokenDetails, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	p, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tok TokenDetails
+	if err := json.Unmarshal(p, &tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// Save implements the TokenStore interface. The file is replaced atomically,
+// so concurrent Load never reads a partially written token.
+func (s *FileTokenStore) Save(tok *TokenDetails) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	p, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
@@ -0,0 +1,92 @@
+package ably_test
+
+import (
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func TestCapability_Allows(t *testing.T) {
+	c := ably.Capability{}.
+		Grant("chat", ably.OpPublish, ably.OpSubscribe).
+		Grant("news:*", ably.OpSubscribe).
+		Grant("admin", ably.OpAll)
+	cases := []struct {
+		resource string
+		op       ably.Operation
+		allowed  bool
+	}{
+		{"chat", ably.OpPublish, true},
+		{"chat", ably.OpPresence, false},
+		{"chatter", ably.OpPublish, false},
+		{"news:sport:live", ably.OpSubscribe, true},
+		{"news:sport", ably.OpSubscribe, true},
+		{"news:sport", ably.OpPublish, false},
+		{"news", ably.OpSubscribe, false},
+		{"admin", ably.OpPushAdmin, true},
+		{"other", ably.OpSubscribe, false},
+	}
+	for _, cas := range cases {
+		if allowed := c.Allows(cas.resource, cas.op); allowed != cas.allowed {
+			t.Errorf("Allows(%q, %q)=%t; want %t", cas.resource, cas.op, allowed, cas.allowed)
+		}
+	}
+	all := ably.Capability{"*": {"subscribe"}}
+	if !all.Allows("any:channel", ably.OpSubscribe) {
+		t.Error("want wildcard resource to match any channel")
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate()=%v", err)
+	}
+	// Wildcards match only whole namespaces.
+	partial := ably.Capability{"chat*": {"*"}, "news:sp*": {"*"}, "feed*:*": {"*"}}
+	for _, resource := range []string{"chatter", "chat", "news:sport", "feeds:sport"} {
+		if partial.Allows(resource, ably.OpSubscribe) {
+			t.Errorf("want %q not to be matched by %v", resource, partial)
+		}
+	}
+}
+
+func TestCapability_Parse(t *testing.T) {
+	c, err := ably.ParseCapability(`{"chat":["publish","subscribe"]}`)
+	if err != nil {
+		t.Fatalf("ParseCapability()=%v", err)
+	}
+	if !c.Allows("chat", ably.OpSubscribe) {
+		t.Errorf("want subscribe on chat to be allowed; got %v", c)
+	}
+	if c, err := ably.ParseCapability(`{"chat":["dance"]}`); err != nil || !c.Allows("chat", "dance") {
+		t.Errorf("want unknown operation to be accepted; got %v (%v)", c, err)
+	}
+	for _, invalid := range []string{
+		`{"chat":["publish",`,
+		`{"chat":[]}`,
+		`{"":["publish"]}`,
+	} {
+		if _, err := ably.ParseCapability(invalid); ably.ErrorCode(err) != 40003 {
+			t.Errorf("ParseCapability(%s): want code=40003; got %d (%v)", invalid, ably.ErrorCode(err), err)
+		}
+	}
+}
+
+func TestCapability_TokenDetails(t *testing.T) {
+	tok := &ably.TokenDetails{RawCapability: `{"chat":[]}`}
+	if _, err := tok.ParseCapability(); ably.ErrorCode(err) != 40003 {
+		t.Errorf("ParseCapability(): want code=40003; got %d (%v)", ably.ErrorCode(err), err)
+	}
+	tok.RawCapability = ""
+	if c, err := tok.ParseCapability(); c != nil || err != nil {
+		t.Errorf("want nil capability and error for empty RawCapability; got %v (%v)", c, err)
+	}
+	params := &ably.TokenParams{RawCapability: `{"chat":["publish"]}`}
+	if c, err := params.ParseCapability(); err != nil || !c.Allows("chat", ably.OpPublish) {
+		t.Errorf("want publish on chat to be allowed; got %v (%v)", c, err)
+	}
+	if c := params.Capability(); !c.Allows("chat", ably.OpPublish) {
+		t.Errorf("want publish on chat to be allowed; got %v", c)
+	}
+	var c ably.Capability
+	if c = c.Grant("chat", ably.OpPublish); !c.Allows("chat", ably.OpPublish) {
+		t.Errorf("want Grant on nil Capability to allocate it; got %v", c)
+	}
+}
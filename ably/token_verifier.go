@@ -0,0 +1,107 @@
+package ably
+
+import (
+	"crypto/hmac"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidMAC      = errors.New("token request signature does not match")
+	errStaleTimestamp  = errors.New("token request timestamp is not current")
+	errReplayedNonce   = errors.New("token request nonce was already used")
+	errMissingTokenReq = errors.New("missing token request")
+)
+
+// NonceStore records nonces of verified token requests, so a request can't
+// be replayed.
+type NonceStore interface {
+	// Add records the given nonce until it expires. It returns false if
+	// the nonce was already recorded and has not expired yet.
+	Add(nonce string, expires time.Time) bool
+}
+
+// MemoryNonceStore is a NonceStore, which keeps nonces in memory.
+// It's safe for concurrent use; expired nonces are purged on Add.
+type MemoryNonceStore struct {
+	mtx    sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryNonceStore gives new, empty MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Add implements the NonceStore interface.
+func (s *MemoryNonceStore) Add(nonce string, expires time.Time) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	for n, t := range s.nonces {
+		if !t.After(now) {
+			delete(s.nonces, n)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = expires
+	return true
+}
+
+// TokenRequestVerifier checks whether token requests were signed with
+// the key and are not stale or replayed.
+type TokenRequestVerifier struct {
+	// MaxAge is the longest time a token request is accepted for after
+	// it was signed, both ways to account for clock skew.
+	//
+	// If MaxAge is zero, one minute is used.
+	MaxAge time.Duration
+
+	// Nonces, when non-nil, is used to reject requests with a nonce, which
+	// was already used by a verified request signed with the same key.
+	Nonces NonceStore
+}
+
+// VerifyTokenRequest checks whether req was signed with the given key secret
+// and its timestamp is current. It does not detect replayed requests, use
+// TokenRequestVerifier with a NonceStore for that.
+func VerifyTokenRequest(req *TokenRequest, keySecret string) error {
+	return (&TokenRequestVerifier{}).Verify(req, keySecret)
+}
+
+// Verify checks whether req was signed with the given key secret, its
+// timestamp is within MaxAge and, if Nonces is set, its nonce was not used
+// before.
+func (v *TokenRequestVerifier) Verify(req *TokenRequest, keySecret string) error {
+	if req == nil {
+		return newError(40000, errMissingTokenReq)
+	}
+	signed := *req
+	signed.sign([]byte(keySecret))
+	if !hmac.Equal([]byte(signed.Mac), []byte(req.Mac)) {
+		return newError(ErrCodeInvalidCredentials, errInvalidMAC)
+	}
+	maxAge := v.maxAge()
+	if d := time.Duration(TimeNow()-req.Timestamp) * time.Millisecond; d > maxAge || d < -maxAge {
+		return newError(ErrCodeTimestampNotCurrent, errStaleTimestamp)
+	}
+	if v.Nonces != nil {
+		expires := time.Unix(0, req.Timestamp*int64(time.Millisecond)).Add(maxAge)
+		if !v.Nonces.Add(req.KeyName+":"+req.Nonce, expires) {
+			return newError(ErrCodeNonceValueReplayed, errReplayedNonce)
+		}
+	}
+	return nil
+}
+
+func (v *TokenRequestVerifier) maxAge() time.Duration {
+	if v.MaxAge != 0 {
+		return v.MaxAge
+	}
+	return time.Minute
+}